@@ -0,0 +1,171 @@
+package chain
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// DefaultMerges is the number of merges learned by the "bpe" Tokenizer if its
+// name doesn't set one.
+const DefaultMerges = 1000
+
+// learner is implemented by Tokenizers that learn how to split text from the
+// corpus before it is counted.
+type learner interface {
+	// learn returns a copy of the tokenizer that has learned from docs.
+	learn(docs [][]byte) Tokenizer
+}
+
+// bpePair is two adjacent tokens that a bpeTokenizer merges into one
+type bpePair struct {
+	a, b string
+}
+
+// bpeTokenizer splits text into subwords by byte-pair encoding. Text is first
+// split into words, each with the whitespace before it, and each word starts
+// as a token per rune. Merges join the most frequent pairs of adjacent tokens
+// in the corpus, in the order they were learned, so frequent words become
+// single tokens while rare words are spelled out in pieces.
+type bpeTokenizer struct {
+	name string
+	// size is the number of merges to learn
+	size   int
+	merges []bpePair
+	ranks  map[bpePair]int
+}
+
+func newBPETokenizer(name string) (*bpeTokenizer, error) {
+	size := DefaultMerges
+	if name != "bpe" {
+		var err error
+		size, err = strconv.Atoi(strings.TrimPrefix(name, "bpe:"))
+		if err != nil || size < 1 {
+			return nil, fmt.Errorf("invalid tokenizer %q: the number of merges must be a positive integer", name)
+		}
+	}
+	return &bpeTokenizer{name: name, size: size, ranks: make(map[bpePair]int)}, nil
+}
+
+func (t *bpeTokenizer) Name() string      { return t.name }
+func (*bpeTokenizer) Separator() string   { return unitSeparator }
+func (*bpeTokenizer) Detokenizer() string { return "concat" }
+
+func (t *bpeTokenizer) Split(data []byte, atEOF bool) (int, []byte, error) {
+	advance, word, err := scanSpacedWord(data, atEOF)
+	if word == nil || err != nil {
+		return advance, word, err
+	}
+	// Every token of a word is the first token of the rest of the word
+	first := t.encode(string(word))[0]
+	return len(first), withoutUnitSeparators(data[:len(first)]), nil
+}
+
+// addMerge appends the merge of pair to the merges of t
+func (t *bpeTokenizer) addMerge(pair bpePair) {
+	t.ranks[pair] = len(t.merges)
+	t.merges = append(t.merges, pair)
+}
+
+// encode splits word into tokens by applying the merges of t in order
+func (t *bpeTokenizer) encode(word string) []string {
+	tokens := strings.Split(word, "")
+	for len(tokens) > 1 {
+		best := len(t.merges)
+		for i := 0; i+1 < len(tokens); i++ {
+			if rank, ok := t.ranks[bpePair{tokens[i], tokens[i+1]}]; ok && rank < best {
+				best = rank
+			}
+		}
+		if best == len(t.merges) {
+			break
+		}
+		tokens = mergePair(tokens, t.merges[best])
+	}
+	return tokens
+}
+
+func (t *bpeTokenizer) learn(docs [][]byte) Tokenizer {
+	counts := make(map[string]int)
+	for _, doc := range docs {
+		for _, word := range splitTokens(scanSpacedWord, string(doc)) {
+			counts[word]++
+		}
+	}
+	// Words are learned from in sorted order so that ties are broken the same
+	// way every time
+	words := make([]string, 0, len(counts))
+	for word := range counts {
+		words = append(words, word)
+	}
+	sort.Strings(words)
+	tokens := make([][]string, len(words))
+	for i, word := range words {
+		tokens[i] = strings.Split(word, "")
+	}
+
+	learned := &bpeTokenizer{name: t.name, size: t.size, ranks: make(map[bpePair]int)}
+	for len(learned.merges) < t.size {
+		frequencies := make(map[bpePair]int)
+		for i, word := range words {
+			for j := 0; j+1 < len(tokens[i]); j++ {
+				frequencies[bpePair{tokens[i][j], tokens[i][j+1]}] += counts[word]
+			}
+		}
+		var best bpePair
+		bestFrequency := 0
+		for pair, frequency := range frequencies {
+			if frequency > bestFrequency || (frequency == bestFrequency && (pair.a < best.a || pair.a == best.a && pair.b < best.b)) {
+				best, bestFrequency = pair, frequency
+			}
+		}
+		// Pairs that are only seen once are not worth a token of their own
+		if bestFrequency < 2 {
+			break
+		}
+		learned.addMerge(best)
+		for i := range tokens {
+			tokens[i] = mergePair(tokens[i], best)
+		}
+	}
+	return learned
+}
+
+// mergePair returns tokens with every occurrence of pair, from left to right,
+// merged into one token
+func mergePair(tokens []string, pair bpePair) []string {
+	merged := make([]string, 0, len(tokens))
+	for i := 0; i < len(tokens); i++ {
+		if i+1 < len(tokens) && tokens[i] == pair.a && tokens[i+1] == pair.b {
+			merged = append(merged, pair.a+pair.b)
+			i++
+		} else {
+			merged = append(merged, tokens[i])
+		}
+	}
+	return merged
+}
+
+// scanSpacedWord is a bufio.SplitFunc that scans a word together with the
+// whitespace before it, or the whitespace at the end of the text
+func scanSpacedWord(data []byte, atEOF bool) (int, []byte, error) {
+	inWord := false
+	for i := 0; i < len(data); {
+		if !atEOF && !utf8.FullRune(data[i:]) {
+			return 0, nil, nil
+		}
+		r, size := utf8.DecodeRune(data[i:])
+		if unicode.IsSpace(r) && inWord {
+			return i, data[:i], nil
+		}
+		inWord = inWord || !unicode.IsSpace(r)
+		i += size
+	}
+	if !atEOF || len(data) == 0 {
+		return 0, nil, nil
+	}
+	return len(data), data, nil
+}
@@ -0,0 +1,52 @@
+package chain
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestBPELearn(t *testing.T) {
+	tests := []struct {
+		name       string
+		tokenizer  string
+		text       string
+		wantMerges []bpePair
+		wantTokens []string
+	}{
+		{"Most frequent pair first", "bpe:10", "aaab aab", []bpePair{{"a", "a"}}, []string{"aa", "a", "b", " ", "aa", "b"}},
+		{"Merge count", "bpe:2", "the then the", []bpePair{{"h", "e"}, {"t", "he"}}, []string{"the", " ", "the", "n", " ", "the"}},
+		{"Whole words", "bpe", "the then the", []bpePair{{"h", "e"}, {"t", "he"}, {" ", "the"}}, []string{"the", " the", "n", " the"}},
+		{"Nothing repeats", "bpe", "abc", nil, []string{"a", "b", "c"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			model := New(Options{N: 1, Mode: TokenMode, Tokenizer: tt.tokenizer})
+			if err := model.Train(strings.NewReader(tt.text)); err != nil {
+				t.Fatalf("Train() error = %v", err)
+			}
+			if got := model.tokenizer.(*bpeTokenizer).merges; !reflect.DeepEqual(got, tt.wantMerges) {
+				t.Errorf("merges = %q, want %q", got, tt.wantMerges)
+			}
+			tokens := model.Tokens(tt.text)
+			if !reflect.DeepEqual(tokens, tt.wantTokens) {
+				t.Errorf("Tokens() = %q, want %q", tokens, tt.wantTokens)
+			}
+			if got := model.detokenize("", tokens); got != tt.text {
+				t.Errorf("detokenize(Tokens()) = %q, want %q", got, tt.text)
+			}
+		})
+	}
+}
+
+func TestParseTokenizerBPE(t *testing.T) {
+	for _, name := range []string{"bpe:0", "bpe:-1", "bpe:many", "bpe:"} {
+		if _, err := ParseTokenizer(name); err == nil {
+			t.Errorf("ParseTokenizer(%q) error = nil, want error", name)
+		}
+	}
+	tokenizer, err := ParseTokenizer("bpe")
+	if err != nil || tokenizer.(*bpeTokenizer).size != DefaultMerges {
+		t.Errorf("ParseTokenizer(\"bpe\") = %v, %v, want %d merges", tokenizer, err, DefaultMerges)
+	}
+}
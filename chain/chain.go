@@ -0,0 +1,204 @@
+// Package chain implements character- and word-level markov chains built from
+// n-gram frequency histograms.
+package chain
+
+import (
+	"fmt"
+	"io"
+	"math/rand"
+	"sort"
+	"strings"
+)
+
+// Version is the version of the markov module. Models record the Version that
+// wrote them so that caches can be rebuilt after an upgrade.
+const Version = "0.4.0-dev"
+
+// StringHistogram maps each n-gram to the frequencies of the n-grams that follow it.
+type StringHistogram = map[string]map[string]uint32
+
+// GetSeparator returns " " if words is true, "" otherwise
+func GetSeparator(words bool) string {
+	if words {
+		return " "
+	} else {
+		return ""
+	}
+}
+
+// GetSeed splits prompt into n-grams if prompt is usable or returns a random n-gram chosen
+// with rng if not. The prompt of a model trained with Unicode normalization
+// options must be normalized with Options.Normalize first, as Generators do.
+func GetSeed(prompt string, n int, lower bool, words bool, hist StringHistogram, rng *rand.Rand) []string {
+	var seed []string
+	separator := GetSeparator(words)
+	if lower {
+		prompt = strings.ToLower(prompt)
+	}
+	// If the prompt contains at least one n-gram's worth of text
+	if promptSplit := strings.Split(prompt, separator); prompt != "" && len(promptSplit) >= n {
+		// Split it on the last n-gram
+		first := strings.Join(promptSplit[:len(promptSplit)-n], separator)
+		last := strings.Join(promptSplit[len(promptSplit)-n:], separator)
+		// fmt.Printf("first: %v, last: %v\n", first, last)
+		// And if the ngram appears in the corpus histogram
+		if _, ok := hist[last]; ok {
+			// Return []string{first, last}
+			if first != "" {
+				seed = append(seed, first)
+			}
+			seed = append(seed, last)
+			return seed
+		}
+	}
+	// Use a random ngram that contains at least one child
+	if randNgram, ok := GetRandomNgram(hist, rng); ok {
+		seed = []string{randNgram}
+	}
+	return seed
+}
+
+// GetRandomNgram uses rng to choose an n-gram that has at least one child in hist.
+// Map iteration order is not used, so the same rng state always chooses the same
+// n-gram. ok is false if no n-gram in hist has a child.
+func GetRandomNgram(hist StringHistogram, rng *rand.Rand) (ngram string, ok bool) {
+	ngrams := make([]string, 0, len(hist))
+	for gram := range hist {
+		if len(hist[gram]) > 0 {
+			ngrams = append(ngrams, gram)
+		}
+	}
+	if len(ngrams) == 0 {
+		return "", false
+	}
+	sort.Strings(ngrams)
+	return ngrams[rng.Intn(len(ngrams))], true
+}
+
+// BuildStringHistogram counts the n-gram transitions in the text read from r
+func BuildStringHistogram(r io.Reader, n int, lowercase bool, words bool) StringHistogram {
+	frequency, _ := buildStringHistogram(r, n, lowercase, words)
+	return frequency
+}
+
+func buildStringHistogram(r io.Reader, n int, lowercase bool, words bool) (StringHistogram, error) {
+	frequency := make(StringHistogram)
+	err := addToStringHistogram(frequency, r, n, lowercase, words)
+	return frequency, err
+}
+
+// addToStringHistogram adds the n-gram transitions in the text read from r to frequency
+func addToStringHistogram(frequency StringHistogram, r io.Reader, n int, lowercase bool, words bool) error {
+	opts := Options{N: n, Lowercase: lowercase, Words: words}
+	tokenizer, _, err := opts.tokenizers()
+	if err != nil {
+		return err
+	}
+	separator := tokenizer.Separator()
+	return countTransitions(r, opts, tokenizer, func(window []string) error {
+		countWindow(window, n, opts.Mode, separator, frequency)
+		return nil
+	})
+}
+
+// countTransitions calls count with every window of tokens in the text read
+// from r, split into tokens by tokenizer: the n tokens of a context followed
+// by the n-gram, or in TokenMode the token, after them, as set by opts.Mode.
+// If opts.Segment is set, each segment is padded with BOS and EOS markers and
+// counted separately. Counting stops at the first error count returns.
+func countTransitions(r io.Reader, opts Options, tokenizer Tokenizer, count func(window []string) error) error {
+	if opts.Segment != SegmentNone {
+		window, _ := opts.window()
+		var countErr error
+		err := scanSegments(r, opts, tokenizer, func(tokens []string) {
+			padded := opts.pad(tokens)
+			for i := 0; i+window <= len(padded) && countErr == nil; i++ {
+				countErr = count(padded[i : i+window])
+			}
+		})
+		if countErr != nil {
+			return countErr
+		}
+		return err
+	}
+	_, _, err := countStream(r, opts, tokenizer, count)
+	return err
+}
+
+// streamWindow returns the number of tokens in each transition of a model that
+// isn't segmented
+func streamWindow(opts Options) int {
+	// In NgramMode the n-gram following buf[0:n] is buf[n:2n], in TokenMode it
+	// is the single token buf[n]
+	if opts.Mode == TokenMode {
+		return opts.N + 1
+	}
+	return opts.N*2 + 1
+}
+
+// countStream calls count with every window of tokens in the unsegmented text
+// read from r, like countTransitions. It returns the first and the last window-1
+// tokens, or every token if there are fewer, which the windows that span the
+// text before and after r start and end with.
+func countStream(r io.Reader, opts Options, tokenizer Tokenizer, count func(window []string) error) (head, tail []string, err error) {
+	scanner := newTokenScanner(r, tokenizer)
+	window := streamWindow(opts)
+	buf := make([]string, 0, window)
+	for scanner.Scan() {
+		token := cleanToken(scanner.Text(), opts)
+		if len(head) < window-1 {
+			head = append(head, token)
+		}
+		buf = append(buf, token)
+		if len(buf) >= window {
+			if err := count(buf); err != nil {
+				return nil, nil, err
+			}
+			buf = buf[1:]
+		}
+	}
+	return head, buf, scanner.Err()
+}
+
+// countWindow counts the transition from the first n tokens of buf to the
+// n-gram, or in TokenMode the token, after them, joining tokens with separator
+func countWindow(buf []string, n int, mode Mode, separator string, frequency StringHistogram) {
+	nextGram := buf[n]
+	if mode == NgramMode {
+		nextGram = strings.Join(buf[n:n*2], separator)
+	}
+	gram := strings.Join(buf[0:n], separator)
+	if _, ok := frequency[gram]; !ok {
+		frequency[gram] = make(map[string]uint32)
+	}
+	frequency[gram][nextGram]++
+}
+
+// GetSamplerFromStringHistogram returns a function that uses rng to sample the n-gram
+// following search, weighted by its frequency in hist. The Chooser of each n-gram
+// is built the first time it is searched for, and the DefaultChooserCacheSize most
+// recently used are kept, so the sampler is safe for concurrent use as long as hist
+// isn't modified.
+func GetSamplerFromStringHistogram(hist StringHistogram) func(search string, rng *rand.Rand) (string, error) {
+	choosers := newChooserCache()
+	return func(search string, rng *rand.Rand) (string, error) {
+		key := chooserKey{gram: search}
+		chooser, ok := choosers.get(key)
+		if !ok {
+			if len(hist[search]) > 0 {
+				chooser = NewChooser(hist[search])
+			}
+			choosers.add(key, chooser, DefaultChooserCacheSize)
+		}
+		if chooser == nil {
+			return "", fmt.Errorf("sample error: %v was not present in the histogram", search)
+		}
+		return chooser.Pick(rng), nil
+	}
+}
+
+// func PrintStringHistogram(hist StringHistogram) {
+// 	for key := range hist {
+// 		fmt.Printf("%v: %v\n", key, hist[key])
+// 	}
+// }
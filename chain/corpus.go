@@ -0,0 +1,79 @@
+package chain
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/bzip2"
+	"compress/gzip"
+	"io"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+var (
+	gzipMagic  = []byte{0x1f, 0x8b, 0x08}
+	bzip2Magic = []byte("BZh")
+	// bzip2BlockMagic follows the block size digit in the bzip2 header
+	bzip2BlockMagic = []byte{0x31, 0x41, 0x59, 0x26, 0x53, 0x59}
+	zstdMagic       = []byte{0x28, 0xb5, 0x2f, 0xfd}
+	tarMagic        = []byte("ustar")
+)
+
+// tarMagicOffset is the offset of the magic field in a tar header
+const tarMagicOffset = 257
+
+// ReadCorpus calls fn with each document in the corpus read from r. Corpora
+// compressed with gzip, bzip2 or zstd are decompressed transparently, and every
+// regular file in a tar archive is a separate document. Compression is detected
+// from the content rather than a file extension, so streams such as standard
+// input can be compressed too. Anything else is read as one plain text document.
+func ReadCorpus(r io.Reader, fn func(doc io.Reader) error) error {
+	br := bufio.NewReader(r)
+	// A short or failed peek just means r isn't compressed, and any read error
+	// is returned again to whoever reads br next
+	header, _ := br.Peek(tarMagicOffset + len(tarMagic))
+	switch {
+	case bytes.HasPrefix(header, gzipMagic):
+		gz, err := gzip.NewReader(br)
+		if err != nil {
+			return err
+		}
+		defer gz.Close()
+		return ReadCorpus(gz, fn)
+	case isBzip2(header):
+		return ReadCorpus(bzip2.NewReader(br), fn)
+	case bytes.HasPrefix(header, zstdMagic):
+		zr, err := zstd.NewReader(br)
+		if err != nil {
+			return err
+		}
+		defer zr.Close()
+		return ReadCorpus(zr, fn)
+	case len(header) > tarMagicOffset && bytes.HasPrefix(header[tarMagicOffset:], tarMagic):
+		tr := tar.NewReader(br)
+		for {
+			entry, err := tr.Next()
+			if err == io.EOF {
+				return nil
+			} else if err != nil {
+				return err
+			}
+			if entry.Typeflag != tar.TypeReg && entry.Typeflag != tar.TypeRegA {
+				continue
+			}
+			if err := ReadCorpus(tr, fn); err != nil {
+				return err
+			}
+		}
+	}
+	return fn(br)
+}
+
+func isBzip2(header []byte) bool {
+	if !bytes.HasPrefix(header, bzip2Magic) || len(header) < len(bzip2Magic)+1+len(bzip2BlockMagic) {
+		return false
+	}
+	level := header[len(bzip2Magic)]
+	return level >= '1' && level <= '9' && bytes.HasPrefix(header[len(bzip2Magic)+1:], bzip2BlockMagic)
+}
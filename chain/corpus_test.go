@@ -0,0 +1,143 @@
+package chain
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// bzip2HelloWorld is "Hello world!" compressed with bzip2, which the standard
+// library can only decompress
+var bzip2HelloWorld = []byte{
+	0x42, 0x5a, 0x68, 0x39, 0x31, 0x41, 0x59, 0x26, 0x53, 0x59, 0x03, 0x58, 0xf5, 0x77, 0x00, 0x00,
+	0x01, 0x15, 0x80, 0x60, 0x00, 0x00, 0x40, 0x06, 0x04, 0x90, 0x80, 0x20, 0x00, 0x31, 0x06, 0x4c,
+	0x41, 0x03, 0x4c, 0x22, 0xe0, 0x8b, 0x62, 0xa3, 0x9e, 0x2e, 0xe4, 0x8a, 0x70, 0xa1, 0x20, 0x06,
+	0xb1, 0xea, 0xee,
+}
+
+func gzipped(t *testing.T, data []byte) []byte {
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	if _, err := w.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func zstdCompressed(t *testing.T, data []byte) []byte {
+	var buf bytes.Buffer
+	w, err := zstd.NewWriter(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func tarred(t *testing.T, files map[string][]byte, names ...string) []byte {
+	var buf bytes.Buffer
+	w := tar.NewWriter(&buf)
+	if err := w.WriteHeader(&tar.Header{Name: "dir/", Typeflag: tar.TypeDir, Mode: 0755}); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range names {
+		header := &tar.Header{Name: name, Typeflag: tar.TypeReg, Mode: 0644, Size: int64(len(files[name]))}
+		if err := w.WriteHeader(header); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Write(files[name]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestReadCorpus(t *testing.T) {
+	hello := []byte("Hello world!")
+	files := map[string][]byte{
+		"dir/a.txt":    []byte("first document"),
+		"dir/b.txt.gz": gzipped(t, []byte("second document")),
+	}
+	archive := tarred(t, files, "dir/a.txt", "dir/b.txt.gz")
+
+	tests := []struct {
+		name    string
+		data    []byte
+		want    []string
+		wantErr bool
+	}{
+		{"Plain text", hello, []string{"Hello world!"}, false},
+		{"Short plain text", []byte("BZh"), []string{"BZh"}, false},
+		{"Empty", nil, []string{""}, false},
+		{"gzip", gzipped(t, hello), []string{"Hello world!"}, false},
+		{"bzip2", bzip2HelloWorld, []string{"Hello world!"}, false},
+		{"zstd", zstdCompressed(t, hello), []string{"Hello world!"}, false},
+		{"tar", archive, []string{"first document", "second document"}, false},
+		{"tar.gz", gzipped(t, archive), []string{"first document", "second document"}, false},
+		{"tar.zst", zstdCompressed(t, archive), []string{"first document", "second document"}, false},
+		{"Corrupt gzip", append(append([]byte{}, gzipMagic...), 0, 0), nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []string
+			err := ReadCorpus(bytes.NewReader(tt.data), func(doc io.Reader) error {
+				b, err := ioutil.ReadAll(doc)
+				got = append(got, string(b))
+				return err
+			})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ReadCorpus() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ReadCorpus() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestModelTrainCompressed(t *testing.T) {
+	text := []byte("Hello world! This is a text string to be used during testing. Its short.")
+	plain := New(Options{N: 2})
+	if err := plain.Train(bytes.NewReader(text)); err != nil {
+		t.Fatalf("Train() error = %v", err)
+	}
+	archive := tarred(t, map[string][]byte{"dir/a.txt": text}, "dir/a.txt")
+	for _, data := range [][]byte{gzipped(t, text), zstdCompressed(t, text), archive} {
+		model := New(Options{N: 2})
+		if err := model.Train(bytes.NewReader(data)); err != nil {
+			t.Fatalf("Train() error = %v", err)
+		}
+		if !reflect.DeepEqual(model.Histogram(), plain.Histogram()) {
+			t.Errorf("Train() = %v, want %v", model.Histogram(), plain.Histogram())
+		}
+		hash, err := HashCorpus(bytes.NewReader(data))
+		if err != nil {
+			t.Fatalf("HashCorpus() error = %v", err)
+		}
+		if hash != model.Corpus.Hash {
+			t.Errorf("HashCorpus() = %v, want %v", hash, model.Corpus.Hash)
+		}
+	}
+
+	if err := New(Options{N: 2}).Train(strings.NewReader(string(gzipMagic) + "corrupt")); err == nil {
+		t.Errorf("Train() error = nil, want error for corrupt gzip")
+	}
+}
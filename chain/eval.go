@@ -0,0 +1,125 @@
+package chain
+
+import (
+	"io"
+	"math"
+)
+
+// Evaluation scores how well a model predicts held-out text.
+type Evaluation struct {
+	// Predictions is the number of next n-grams, or in TokenMode tokens, that
+	// were predicted from the n tokens before them.
+	Predictions int
+	// Tokens is the number of tokens in the predictions that were scored.
+	Tokens int
+	// OOV is the number of predictions whose next n-gram is not in the model's
+	// vocabulary. They have no probability under any Estimator, so they are
+	// left out of LogProb and Tokens.
+	OOV int
+	// UnseenContexts is the number of predictions whose n tokens of context
+	// never appeared in the training text.
+	UnseenContexts int
+	// LogProb is the sum of the natural log-probabilities of every scored
+	// prediction.
+	LogProb float64
+}
+
+// LogProbPerToken returns the average natural log-probability of each scored token.
+func (e Evaluation) LogProbPerToken() float64 {
+	if e.Tokens == 0 {
+		return 0
+	}
+	return e.LogProb / float64(e.Tokens)
+}
+
+// CrossEntropy returns the cross-entropy of the held-out text in bits per token.
+func (e Evaluation) CrossEntropy() float64 {
+	return -e.LogProbPerToken() / math.Ln2
+}
+
+// Perplexity returns the perplexity of the held-out text per token.
+func (e Evaluation) Perplexity() float64 {
+	return math.Exp(-e.LogProbPerToken())
+}
+
+// OOVRate returns the fraction of predictions whose next n-gram is out of vocabulary.
+func (e Evaluation) OOVRate() float64 {
+	if e.Predictions == 0 {
+		return 0
+	}
+	return float64(e.OOV) / float64(e.Predictions)
+}
+
+// UnseenContextRate returns the fraction of predictions whose context was never seen.
+func (e Evaluation) UnseenContextRate() float64 {
+	if e.Predictions == 0 {
+		return 0
+	}
+	return float64(e.UnseenContexts) / float64(e.Predictions)
+}
+
+// Evaluate scores how well m, using the probabilities estimated by e, predicts
+// the text read from readers. The text is split into tokens like training text,
+// and every document read by ReadCorpus is scored separately. In TokenMode every
+// token after the first n of a document is predicted from the n before it. In
+// NgramMode the text is predicted one n-gram at a time, the way it is generated.
+// With MaximumLikelihood estimates any unseen transition makes the perplexity
+// infinite.
+func Evaluate(m *Model, e Estimator, readers ...io.Reader) (Evaluation, error) {
+	var eval Evaluation
+	for _, r := range readers {
+		err := ReadCorpus(r, func(doc io.Reader) error {
+			return m.evaluate(e, doc, &eval)
+		})
+		if err != nil {
+			return eval, err
+		}
+	}
+	return eval, nil
+}
+
+func (m *Model) evaluate(e Estimator, r io.Reader, eval *Evaluation) error {
+	r = m.Options.normalizeReader(r)
+	t := m.smoothingTables(e)
+	window, step := m.Options.window()
+	if m.Options.Segment != SegmentNone {
+		return scanSegments(r, m.Options, m.tokenizer, func(tokens []string) {
+			padded := m.Options.pad(tokens)
+			for i := 0; i+window <= len(padded); i += step {
+				m.score(e, t, padded[i:i+window], eval)
+			}
+		})
+	}
+	scanner := newTokenScanner(r, m.tokenizer)
+	buf := make([]string, 0, window)
+	for scanner.Scan() {
+		buf = append(buf, cleanToken(scanner.Text(), m.Options))
+		if len(buf) < window {
+			continue
+		}
+		m.score(e, t, buf, eval)
+		buf = buf[step:]
+	}
+	return scanner.Err()
+}
+
+// score adds the prediction of the tokens after the first n of buf to eval. The
+// EOS markers that pad the end of a segment count as one token.
+func (m *Model) score(e Estimator, t *smoothingTables, buf []string, eval *Evaluation) {
+	n := m.Options.N
+	context, next := buf[:n], m.join(buf[n:])
+	eval.Predictions++
+	if !m.has(context) {
+		eval.UnseenContexts++
+	}
+	if !t.inVocabulary(next) {
+		eval.OOV++
+		return
+	}
+	tokens := buf[n:]
+	if i := indexOf(tokens, EOS); i >= 0 {
+		tokens = tokens[:i+1]
+	}
+	eval.Tokens += len(tokens)
+	eval.LogProb += math.Log(m.prob(e, t, context, next))
+}
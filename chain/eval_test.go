@@ -0,0 +1,56 @@
+package chain
+
+import (
+	"math"
+	"strings"
+	"testing"
+)
+
+func TestEvaluate(t *testing.T) {
+	tokenModel := New(Options{N: 1, Mode: TokenMode})
+	if err := tokenModel.Train(strings.NewReader("abab")); err != nil {
+		t.Fatalf("Train() error = %v", err)
+	}
+	ngramModel := New(Options{N: 2})
+	if err := ngramModel.Train(strings.NewReader("abcabc")); err != nil {
+		t.Fatalf("Train() error = %v", err)
+	}
+	segmentModel := New(Options{N: 1, Mode: TokenMode, Segment: SegmentLines})
+	if err := segmentModel.Train(strings.NewReader("ab\nab\n")); err != nil {
+		t.Fatalf("Train() error = %v", err)
+	}
+	tests := []struct {
+		name           string
+		model          *Model
+		estimator      Estimator
+		text           string
+		want           Evaluation
+		wantPerplexity float64
+	}{
+		{"Training text", tokenModel, Estimator{}, "abab", Evaluation{Predictions: 3, Tokens: 3}, 1},
+		{"Unseen transition", tokenModel, Estimator{}, "aab", Evaluation{Predictions: 2, Tokens: 2, LogProb: math.Inf(-1)}, math.Inf(1)},
+		{"Laplace", tokenModel, Estimator{Smoothing: AddK}, "aa", Evaluation{Predictions: 1, Tokens: 1, LogProb: math.Log(0.25)}, 4},
+		{"Out of vocabulary", tokenModel, Estimator{Smoothing: AddK}, "ac", Evaluation{Predictions: 1, OOV: 1}, 1},
+		{"Unseen context", tokenModel, Estimator{Smoothing: AddK}, "ca", Evaluation{Predictions: 1, Tokens: 1, UnseenContexts: 1, LogProb: math.Log(0.5)}, 2},
+		{"N-grams", ngramModel, Estimator{}, "abca", Evaluation{Predictions: 1, Tokens: 2}, 1},
+		{"Too short", ngramModel, Estimator{}, "abc", Evaluation{}, 1},
+		{"Segments", segmentModel, Estimator{}, "ab\n", Evaluation{Predictions: 3, Tokens: 3}, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Evaluate(tt.model, tt.estimator, strings.NewReader(tt.text))
+			if err != nil {
+				t.Fatalf("Evaluate() error = %v", err)
+			}
+			sameLogProb := got.LogProb == tt.want.LogProb || math.Abs(got.LogProb-tt.want.LogProb) < 1e-9
+			counts, wantCounts := got, tt.want
+			counts.LogProb, wantCounts.LogProb = 0, 0
+			if counts != wantCounts || !sameLogProb {
+				t.Errorf("Evaluate() = %+v, want %+v", got, tt.want)
+			}
+			if perplexity := got.Perplexity(); perplexity != tt.wantPerplexity && math.Abs(perplexity-tt.wantPerplexity) > 1e-9 {
+				t.Errorf("Perplexity() = %v, want %v", perplexity, tt.wantPerplexity)
+			}
+		})
+	}
+}
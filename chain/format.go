@@ -0,0 +1,471 @@
+package chain
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+)
+
+// The binary model format is a stream of unsigned varints and length-prefixed
+// strings:
+//
+//	magic       "MRKV"
+//	version     uvarint
+//	header      tool version, n, flags (bit 0 lowercase, bit 1 words, bit 2
+//	            backoff, bit 3 token mode, bits 4-5 segment, bits 6-7
+//	            normalization, bit 8 case fold, bit 9 strip accents, bit 10
+//	            strip control), tokenizer, detokenizer, corpus hash, corpus
+//	            fingerprint
+//	merges      if the tokenizer is bpe, count, then the two tokens of each
+//	            merge in the order they were learned
+//	vocabulary  count, then every token in sorted order
+//	transitions the trie of contexts of n tokens: level count, then for each
+//	            level, node count, then (token id delta, count, child count)
+//	            for each node
+//	backoff     if backoff is set, the trie of each context length from 1 to
+//	            n-1, in the same layout
+//
+// Tokens are interned in the vocabulary and referred to by their index. Each
+// path through a trie is the tokens of a context followed by those of a next
+// n-gram, and the count of its last node is the frequency of the transition.
+// Nodes are written a level at a time, ordered by parent and then by token id,
+// and each token id is written as the difference from the sibling before it
+// to keep the varints small.
+//
+// Versions 1 and 2, which are still read but no longer written, intern whole
+// n-grams in the vocabulary instead, and write the transitions as a count,
+// then for each n-gram with children, its id, its child count, then (id delta,
+// frequency) for each child. Version 1 has no detokenizer, and uses the one
+// paired with the tokenizer.
+const (
+	formatMagic = "MRKV"
+	// FormatVersion is the version of the binary model format written by Save.
+	FormatVersion = 3
+)
+
+// maxN is the longest n-gram length a model file may have
+const maxN = 1 << 10
+
+const (
+	flagLowercase = 1 << iota
+	flagWords
+	flagBackoff
+	flagTokenMode
+	// flagSegmentShift is the position of the two bits holding Options.Segment
+	flagSegmentShift = 4
+	flagSegmentMask  = 3 << flagSegmentShift
+	// flagNormalizationShift is the position of the two bits holding
+	// Options.Normalization
+	flagNormalizationShift = 6
+	flagNormalizationMask  = 3 << flagNormalizationShift
+	flagCaseFold           = 1 << 8
+	flagStripAccents       = 1 << 9
+	flagStripControl       = 1 << 10
+)
+
+// ErrFormat is returned when reading data that is not a binary model.
+var ErrFormat = errors.New("model error: not a markov model file")
+
+// Header describes the corpus and options a binary model was trained with.
+type Header struct {
+	// Version is the version of the binary model format.
+	Version uint64
+	// ToolVersion is the Version of the package that wrote the model.
+	ToolVersion string
+	Options     Options
+	// Tokenizer and Detokenizer are the names of the model's Tokenizer and
+	// Detokenizer, even if its Options leave them empty.
+	Tokenizer   string
+	Detokenizer string
+	Corpus      Corpus
+	// Mapped is set if the model is in the layout written by WriteMappedModel.
+	Mapped bool
+}
+
+type formatWriter struct {
+	w   *bufio.Writer
+	buf [binary.MaxVarintLen64]byte
+	err error
+}
+
+func (fw *formatWriter) uvarint(x uint64) {
+	if fw.err != nil {
+		return
+	}
+	n := binary.PutUvarint(fw.buf[:], x)
+	_, fw.err = fw.w.Write(fw.buf[:n])
+}
+
+func (fw *formatWriter) string(s string) {
+	fw.uvarint(uint64(len(s)))
+	if fw.err != nil {
+		return
+	}
+	_, fw.err = fw.w.WriteString(s)
+}
+
+type formatReader struct {
+	r   *bufio.Reader
+	err error
+}
+
+func (fr *formatReader) uvarint() uint64 {
+	if fr.err != nil {
+		return 0
+	}
+	var x uint64
+	x, fr.err = binary.ReadUvarint(fr.r)
+	if fr.err == io.EOF {
+		fr.err = io.ErrUnexpectedEOF
+	}
+	return x
+}
+
+func (fr *formatReader) string() string {
+	n := fr.uvarint()
+	if fr.err != nil {
+		return ""
+	}
+	if n > 1<<30 {
+		fr.err = fmt.Errorf("model error: string of length %d is too long", n)
+		return ""
+	}
+	b := make([]byte, n)
+	if _, err := io.ReadFull(fr.r, b); err != nil {
+		fr.err = io.ErrUnexpectedEOF
+		return ""
+	}
+	return string(b)
+}
+
+// WriteModel writes the header and histogram of m to w in the binary model format.
+func WriteModel(w io.Writer, m *Model) error {
+	fw := &formatWriter{w: bufio.NewWriter(w)}
+	fw.header(m)
+	counts := storeOf(m.counts, m.Options)
+	fw.uvarint(uint64(len(counts.tokens)))
+	for _, token := range counts.tokens {
+		fw.string(token)
+	}
+	fw.trie(counts.trie(m.Options.N))
+	if m.Options.Backoff {
+		for k := 1; k < m.Options.N; k++ {
+			fw.trie(counts.trie(k))
+		}
+	}
+	if fw.err != nil {
+		return fw.err
+	}
+	return fw.w.Flush()
+}
+
+// header writes the header of m, followed by the merges of its tokenizer
+func (fw *formatWriter) header(m *Model) {
+	_, fw.err = fw.w.WriteString(formatMagic)
+	fw.uvarint(FormatVersion)
+	fw.string(Version)
+
+	var flags uint64
+	if m.Options.Lowercase {
+		flags |= flagLowercase
+	}
+	if m.Options.Words {
+		flags |= flagWords
+	}
+	if m.Options.Backoff {
+		flags |= flagBackoff
+	}
+	if m.Options.Mode == TokenMode {
+		flags |= flagTokenMode
+	}
+	flags |= uint64(m.Options.Segment) << flagSegmentShift & flagSegmentMask
+	flags |= uint64(m.Options.Normalization) << flagNormalizationShift & flagNormalizationMask
+	if m.Options.CaseFold {
+		flags |= flagCaseFold
+	}
+	if m.Options.StripAccents {
+		flags |= flagStripAccents
+	}
+	if m.Options.StripControl {
+		flags |= flagStripControl
+	}
+	fw.uvarint(uint64(m.Options.N))
+	fw.uvarint(flags)
+	fw.string(m.tokenizer.Name())
+	fw.string(m.detokenizer.Name())
+	fw.string(m.Corpus.Hash)
+	fw.string(m.Corpus.Fingerprint)
+	if bpe, ok := m.tokenizer.(*bpeTokenizer); ok {
+		fw.uvarint(uint64(len(bpe.merges)))
+		for _, pair := range bpe.merges {
+			fw.string(pair.a)
+			fw.string(pair.b)
+		}
+	}
+}
+
+// trie writes the levels of t, or none if t is nil
+func (fw *formatWriter) trie(t *trie) {
+	var levels []level
+	if t != nil {
+		levels = t.levels
+	}
+	fw.uvarint(uint64(len(levels)))
+	for d, l := range levels {
+		fw.uvarint(uint64(len(l.tokens)))
+		// Siblings are in token order, so each is written as the difference
+		// from the one before it
+		siblings := []uint32{0, uint32(len(l.tokens))}
+		if d > 0 {
+			siblings = levels[d-1].children
+		}
+		for p := 0; p+1 < len(siblings); p++ {
+			var prev uint32
+			for i := siblings[p]; i < siblings[p+1]; i++ {
+				fw.uvarint(uint64(l.tokens[i] - prev))
+				fw.uvarint(uint64(l.counts[i]))
+				fw.uvarint(uint64(l.children[i+1] - l.children[i]))
+				prev = l.tokens[i]
+			}
+		}
+	}
+}
+
+func readHeader(fr *formatReader) (Header, error) {
+	magic := make([]byte, len(formatMagic))
+	if _, err := io.ReadFull(fr.r, magic); err != nil || string(magic) != formatMagic {
+		return Header{}, ErrFormat
+	}
+	var h Header
+	h.Version = fr.uvarint()
+	if fr.err == nil && (h.Version < 1 || h.Version > FormatVersion) {
+		return Header{}, fmt.Errorf("model error: unsupported format version %d", h.Version)
+	}
+	h.ToolVersion = fr.string()
+	n := fr.uvarint()
+	if fr.err == nil && (n < 1 || n > maxN) {
+		return Header{}, fmt.Errorf("model error: invalid n-gram length %d", n)
+	}
+	h.Options.N = int(n)
+	flags := fr.uvarint()
+	h.Options.Lowercase = flags&flagLowercase != 0
+	h.Options.Words = flags&flagWords != 0
+	h.Options.Backoff = flags&flagBackoff != 0
+	if flags&flagTokenMode != 0 {
+		h.Options.Mode = TokenMode
+	}
+	h.Options.Segment = Segment(flags & flagSegmentMask >> flagSegmentShift)
+	h.Options.Normalization = Normalization(flags & flagNormalizationMask >> flagNormalizationShift)
+	h.Options.CaseFold = flags&flagCaseFold != 0
+	h.Options.StripAccents = flags&flagStripAccents != 0
+	h.Options.StripControl = flags&flagStripControl != 0
+	h.Tokenizer = fr.string()
+	if h.Version >= 2 {
+		h.Detokenizer = fr.string()
+	}
+	// Options only name the tokenizers that they don't choose by default
+	if h.Tokenizer != GetTokenizerName(h.Options.Words) {
+		h.Options.Tokenizer = h.Tokenizer
+	}
+	if tokenizer, err := ParseTokenizer(h.Tokenizer); err == nil {
+		if h.Detokenizer == "" {
+			h.Detokenizer = tokenizer.Detokenizer()
+		} else if h.Detokenizer != tokenizer.Detokenizer() {
+			h.Options.Detokenizer = h.Detokenizer
+		}
+	}
+	h.Corpus.Hash = fr.string()
+	h.Corpus.Fingerprint = fr.string()
+	return h, fr.err
+}
+
+// ReadHeader reads only the header of a binary model from r.
+func ReadHeader(r io.Reader) (Header, error) {
+	fr := &formatReader{r: bufio.NewReader(r)}
+	// Mapped models start with the header of a binary model
+	mapped := fr.mapped()
+	if mapped {
+		if _, err := fr.r.Discard(mappedHeaderSize); err != nil {
+			return Header{}, io.ErrUnexpectedEOF
+		}
+	}
+	h, err := readHeader(fr)
+	h.Mapped = mapped
+	return h, err
+}
+
+// ReadModel reads a model written by WriteModel or WriteMappedModel from r.
+// Mapped models are read into memory.
+func ReadModel(r io.Reader) (*Model, error) {
+	fr := &formatReader{r: bufio.NewReader(r)}
+	if fr.mapped() {
+		data, err := ioutil.ReadAll(fr.r)
+		if err != nil {
+			return nil, err
+		}
+		return openMapped(data)
+	}
+	m, h, err := fr.model()
+	if err != nil {
+		return nil, err
+	}
+	if h.Version >= 3 {
+		m.counts = fr.readCounts(h.Options)
+		if fr.err != nil {
+			return nil, fr.err
+		}
+		return m, nil
+	}
+
+	// Versions 1 and 2 intern whole n-grams and write the transitions of each
+	// one
+	vocabSize := fr.uvarint()
+	var vocab []string
+	for i := uint64(0); i < vocabSize && fr.err == nil; i++ {
+		vocab = append(vocab, fr.string())
+	}
+	lookup := func(id uint64) string {
+		if id >= uint64(len(vocab)) {
+			if fr.err == nil {
+				fr.err = fmt.Errorf("model error: n-gram id %d is out of range", id)
+			}
+			return ""
+		}
+		return vocab[id]
+	}
+
+	hists := []StringHistogram{fr.transitions(lookup)}
+	if h.Options.Backoff {
+		for k := 1; k < h.Options.N; k++ {
+			hists = append(hists, fr.transitions(lookup))
+		}
+	}
+	if fr.err != nil {
+		return nil, fr.err
+	}
+	counts := newStore(h.Options, 0)
+	for i, hist := range hists {
+		k := i
+		if i == 0 {
+			k = h.Options.N
+		}
+		if err := m.storeHistogram(counts, k, hist); err != nil {
+			return nil, err
+		}
+	}
+	counts.freeze()
+	m.counts = counts
+	return m, nil
+}
+
+// mapped reports whether the model being read is a mapped model
+func (fr *formatReader) mapped() bool {
+	magic, err := fr.r.Peek(len(mappedMagic))
+	return err == nil && string(magic) == mappedMagic
+}
+
+// model reads the header of a binary model and the merges of its tokenizer,
+// and returns an empty Model with its Options and Corpus
+func (fr *formatReader) model() (*Model, Header, error) {
+	h, err := readHeader(fr)
+	if err != nil {
+		return nil, h, err
+	}
+	m := New(h.Options)
+	if _, _, err := h.Options.tokenizers(); err != nil {
+		return nil, h, fmt.Errorf("model error: %v", err)
+	}
+	if bpe, ok := m.tokenizer.(*bpeTokenizer); ok {
+		merges := fr.uvarint()
+		for i := uint64(0); i < merges && fr.err == nil; i++ {
+			bpe.addMerge(bpePair{fr.string(), fr.string()})
+		}
+	}
+	m.Corpus = h.Corpus
+	return m, h, fr.err
+}
+
+// readCounts reads the counts written by WriteModel since version 3
+func (fr *formatReader) readCounts(opts Options) *store {
+	counts := &store{n: opts.N, mode: opts.Mode, tries: make([]*trie, opts.N)}
+	size := fr.uvarint()
+	for i := uint64(0); i < size && fr.err == nil; i++ {
+		token := fr.string()
+		if i > 0 && token <= counts.tokens[i-1] && fr.err == nil {
+			fr.err = fmt.Errorf("model error: token %q is out of order", token)
+		}
+		counts.tokens = append(counts.tokens, token)
+	}
+	counts.tries[opts.N-1] = fr.trie(len(counts.tokens))
+	if opts.Backoff {
+		for k := 1; k < opts.N; k++ {
+			counts.tries[k-1] = fr.trie(len(counts.tokens))
+		}
+	}
+	return counts
+}
+
+// trie reads a trie written by formatWriter.trie, of tokens with ids below
+// size
+func (fr *formatReader) trie(size int) *trie {
+	t := &trie{}
+	depth := fr.uvarint()
+	var siblings []uint32
+	for d := uint64(0); d < depth && fr.err == nil; d++ {
+		nodes := fr.uvarint()
+		if d == 0 {
+			siblings = []uint32{0, uint32(nodes)}
+		}
+		if uint64(siblings[len(siblings)-1]) != nodes {
+			fr.err = fmt.Errorf("model error: trie level %d has %d nodes, want %d", d, nodes, siblings[len(siblings)-1])
+			break
+		}
+		l := level{children: []uint32{0}}
+		for p := 0; p+1 < len(siblings) && fr.err == nil; p++ {
+			var token uint64
+			for i := siblings[p]; i < siblings[p+1] && fr.err == nil; i++ {
+				delta := fr.uvarint()
+				token += delta
+				if (delta == 0 && i > siblings[p]) || token >= uint64(size) {
+					if fr.err == nil {
+						fr.err = fmt.Errorf("model error: token id %d is out of range or order", token)
+					}
+					break
+				}
+				l.tokens = append(l.tokens, uint32(token))
+				l.counts = append(l.counts, uint32(fr.uvarint()))
+				l.children = append(l.children, l.children[len(l.children)-1]+uint32(fr.uvarint()))
+			}
+		}
+		t.levels = append(t.levels, l)
+		siblings = l.children
+	}
+	if fr.err == nil && len(siblings) > 0 && siblings[len(siblings)-1] != 0 {
+		fr.err = fmt.Errorf("model error: the last trie level has children")
+	}
+	return t
+}
+
+// transitions reads the histogram of a model file of version 1 or 2, in which
+// each n-gram is an id in the vocabulary. Nothing writes these versions any
+// more; they are only read.
+func (fr *formatReader) transitions(lookup func(uint64) string) StringHistogram {
+	hist := make(StringHistogram)
+	contexts := fr.uvarint()
+	for i := uint64(0); i < contexts && fr.err == nil; i++ {
+		gram := lookup(fr.uvarint())
+		count := fr.uvarint()
+		nextGrams := make(map[string]uint32)
+		var id uint64
+		for j := uint64(0); j < count && fr.err == nil; j++ {
+			id += fr.uvarint()
+			nextGram := lookup(id)
+			nextGrams[nextGram] = uint32(fr.uvarint())
+		}
+		hist[gram] = nextGrams
+	}
+	return hist
+}
@@ -0,0 +1,170 @@
+package chain
+
+import (
+	"bufio"
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestWriteReadModel(t *testing.T) {
+	text := "Hello world! This is a text string to be used during testing. Its short."
+	tests := []struct {
+		name        string
+		opts        Options
+		tokenizer   string
+		detokenizer string
+	}{
+		{"Characters", Options{N: 3}, "runes", "concat"},
+		{"Lowercase characters", Options{N: 1, Lowercase: true}, "runes", "concat"},
+		{"Words", Options{N: 2, Words: true}, "words", "space"},
+		{"Lowercase words", Options{N: 1, Lowercase: true, Words: true}, "words", "space"},
+		{"Backoff", Options{N: 3, Backoff: true}, "runes", "concat"},
+		{"Token mode", Options{N: 2, Mode: TokenMode, Words: true}, "words", "space"},
+		{"Long n-grams", Options{N: 8, Backoff: true}, "runes", "concat"},
+		{"Segments", Options{N: 2, Mode: TokenMode, Words: true, Segment: SegmentSentences}, "words", "space"},
+		{"Tokenizer", Options{N: 2, Tokenizer: "punctuation"}, "punctuation", "punctuation"},
+		{"Regex tokenizer", Options{N: 1, Tokenizer: `regex:\w+`, Detokenizer: "concat"}, `regex:\w+`, "concat"},
+		{"Normalization", Options{N: 2, Normalization: NormalizeNFKC, CaseFold: true, StripAccents: true, StripControl: true}, "runes", "concat"},
+		{"BPE tokenizer", Options{N: 2, Mode: TokenMode, Tokenizer: "bpe:20"}, "bpe:20", "concat"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			model := New(tt.opts)
+			if err := model.Train(strings.NewReader(text)); err != nil {
+				t.Fatalf("Train() error = %v", err)
+			}
+			model.Corpus.Fingerprint = "corpus.txt:72"
+			var buf bytes.Buffer
+			if err := WriteModel(&buf, model); err != nil {
+				t.Fatalf("WriteModel() error = %v", err)
+			}
+
+			header, err := ReadHeader(bytes.NewReader(buf.Bytes()))
+			if err != nil {
+				t.Fatalf("ReadHeader() error = %v", err)
+			}
+			wantHeader := Header{
+				Version:     FormatVersion,
+				ToolVersion: Version,
+				Options:     tt.opts,
+				Tokenizer:   tt.tokenizer,
+				Detokenizer: tt.detokenizer,
+				Corpus:      model.Corpus,
+			}
+			if header != wantHeader {
+				t.Errorf("ReadHeader() = %+v, want %+v", header, wantHeader)
+			}
+
+			loaded, err := ReadModel(&buf)
+			if err != nil {
+				t.Fatalf("ReadModel() error = %v", err)
+			}
+			if loaded.Options != tt.opts || loaded.Corpus != model.Corpus {
+				t.Errorf("ReadModel() options = %+v %+v, want %+v %+v", loaded.Options, loaded.Corpus, tt.opts, model.Corpus)
+			}
+			if !reflect.DeepEqual(loaded.Histogram(), model.Histogram()) {
+				t.Errorf("ReadModel() = %v, want %v", loaded.Histogram(), model.Histogram())
+			}
+			for k := 1; k < tt.opts.N; k++ {
+				if !reflect.DeepEqual(loaded.histogram(k), model.histogram(k)) {
+					t.Errorf("ReadModel() backoff %d = %v, want %v", k, loaded.histogram(k), model.histogram(k))
+				}
+			}
+			if got, want := loaded.Tokens(text), model.Tokens(text); !reflect.DeepEqual(got, want) {
+				t.Errorf("ReadModel() tokens = %q, want %q", got, want)
+			}
+		})
+	}
+}
+
+func TestReadModelErrors(t *testing.T) {
+	model := New(Options{N: 2})
+	if err := model.Train(strings.NewReader("Hello world!")); err != nil {
+		t.Fatalf("Train() error = %v", err)
+	}
+	var buf bytes.Buffer
+	if err := WriteModel(&buf, model); err != nil {
+		t.Fatalf("WriteModel() error = %v", err)
+	}
+	valid := buf.Bytes()
+
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"Empty", nil},
+		{"JSON", []byte(`{"he":{"ll":1}}`)},
+		{"Unsupported version", append([]byte(formatMagic), 99)},
+		{"Unknown tokenizer", bytes.Replace(valid, []byte("\x05runes"), []byte("\x05nouns"), 1)},
+		{"Truncated", valid[:len(valid)-3]},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := ReadModel(bytes.NewReader(tt.data)); err == nil {
+				t.Errorf("ReadModel() error = nil, want error")
+			}
+		})
+	}
+}
+
+func TestReadModelVersion1(t *testing.T) {
+	var buf bytes.Buffer
+	fw := &formatWriter{w: bufio.NewWriter(&buf)}
+	fw.w.WriteString(formatMagic)
+	fw.uvarint(1)
+	fw.string("0.3.0")
+	fw.uvarint(2)
+	fw.uvarint(flagWords)
+	fw.string("words")
+	fw.string("hash")
+	fw.string("")
+	fw.uvarint(2)
+	fw.string("one two")
+	fw.string("three four")
+	fw.uvarint(1)
+	fw.uvarint(0)
+	fw.uvarint(1)
+	fw.uvarint(1)
+	fw.uvarint(3)
+	if err := fw.w.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	model, err := ReadModel(&buf)
+	if err != nil {
+		t.Fatalf("ReadModel() error = %v", err)
+	}
+	if want := (Options{N: 2, Words: true}); model.Options != want {
+		t.Errorf("ReadModel() options = %+v, want %+v", model.Options, want)
+	}
+	if want := (StringHistogram{"one two": {"three four": 3}}); !reflect.DeepEqual(model.Histogram(), want) {
+		t.Errorf("ReadModel() = %v, want %v", model.Histogram(), want)
+	}
+	if got := model.Generate("one two", 2); got != "one two three four" {
+		t.Errorf("Generate() = %q, want %q", got, "one two three four")
+	}
+}
+
+func TestModelExportImportJSON(t *testing.T) {
+	opts := Options{N: 2, Words: true}
+	model := New(opts)
+	if err := model.Train(strings.NewReader("one two three four five six")); err != nil {
+		t.Fatalf("Train() error = %v", err)
+	}
+	var buf bytes.Buffer
+	if err := model.ExportJSON(&buf); err != nil {
+		t.Fatalf("ExportJSON() error = %v", err)
+	}
+	if want := "{\"one two\":{\"three four\":1},\"two three\":{\"four five\":1}}\n"; buf.String() != want {
+		t.Errorf("ExportJSON() = %q, want %q", buf.String(), want)
+	}
+	imported := New(opts)
+	if err := imported.ImportJSON(&buf); err != nil {
+		t.Fatalf("ImportJSON() error = %v", err)
+	}
+	if !reflect.DeepEqual(imported.Histogram(), model.Histogram()) {
+		t.Errorf("ImportJSON() = %v, want %v", imported.Histogram(), model.Histogram())
+	}
+}
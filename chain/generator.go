@@ -0,0 +1,238 @@
+package chain
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"strings"
+)
+
+// DeadEnd is what a Generator does when the current n-gram has no next n-grams,
+// either because it never appeared in the corpus or because it only appeared at
+// the very end of it.
+type DeadEnd int
+
+const (
+	// Stop ends generation early.
+	Stop DeadEnd = iota
+	// Backoff samples the next n-gram from the longest shorter context that has
+	// one, like stupid backoff. It needs a model trained with Options.Backoff,
+	// and stops if no shorter context has a next n-gram.
+	Backoff
+	// Restart continues from a random n-gram, or from the start of a segment if
+	// the model was trained with Options.Segment.
+	Restart
+)
+
+var deadEndNames = []string{"stop", "backoff", "restart"}
+
+func (d DeadEnd) String() string {
+	if d < 0 || int(d) >= len(deadEndNames) {
+		return fmt.Sprintf("DeadEnd(%d)", int(d))
+	}
+	return deadEndNames[d]
+}
+
+// ParseDeadEnd returns the DeadEnd named name: "stop", "backoff" or "restart"
+func ParseDeadEnd(name string) (DeadEnd, error) {
+	for d, deadEndName := range deadEndNames {
+		if name == deadEndName {
+			return DeadEnd(d), nil
+		}
+	}
+	return Stop, fmt.Errorf("unknown dead end strategy %q, must be one of %s", name, strings.Join(deadEndNames, ", "))
+}
+
+// Generator samples text from a Model. Each Generator owns its random source, so
+// two Generators created with the same seed produce identical text from the same
+// model.
+type Generator struct {
+	Model *Model
+	Rand  *rand.Rand
+	// DeadEnd is what to do when the current n-gram has no next n-grams.
+	DeadEnd DeadEnd
+	// Sampling reshapes the distribution of next n-grams before each is sampled.
+	Sampling Sampling
+	// Estimator estimates the distribution of next n-grams. With any smoothing
+	// other than MaximumLikelihood every n-gram in the vocabulary can follow,
+	// so generation never reaches a dead end and any prompt is continued.
+	Estimator Estimator
+	// Segments is the number of segments to generate from a model trained with
+	// Options.Segment before stopping. 0 is treated as 1.
+	Segments int
+}
+
+// NewGenerator returns a Generator for m whose random source is seeded with seed.
+func NewGenerator(m *Model, seed int64) *Generator {
+	return &Generator{Model: m, Rand: rand.New(rand.NewSource(seed))}
+}
+
+// Generate continues prompt by sampling up to max tokens (characters, or words
+// with the words Tokenizer) from the model. If the prompt's last n-gram was never
+// seen during training, a random n-gram is used to start instead, unless the
+// Generator backs off and a shorter context of the prompt was seen, or smooths
+// its Estimator. Neither the prompt nor the random n-gram count towards max. In
+// NgramMode the last n-gram is truncated to fit max. Fewer tokens may be
+// generated if the sequence reaches a dead end and the Generator stops.
+//
+// If the model was trained with Options.Segment, an empty or unseen prompt
+// starts a new segment the way segments started in the corpus, and generation
+// also stops after the end of Segments segments. Segments are separated by a
+// space for sentences, a newline for lines and a blank line for documents. The
+// BOS and EOS markers are never generated.
+func (g *Generator) Generate(prompt string, max int) string {
+	var text strings.Builder
+	g.GenerateTo(prompt, max, func(piece string) error {
+		text.WriteString(piece)
+		return nil
+	})
+	return text.String()
+}
+
+// GenerateTo is like Generate, but calls emit with each piece of text as soon as
+// it is sampled instead of returning it all at the end: first the prompt or the
+// random n-gram, then each next n-gram, or token in TokenMode, preceded by the
+// text the model's Detokenizer writes between tokens. The pieces add up to the text Generate returns.
+// Generation stops as soon as emit returns an error, which GenerateTo returns.
+func (g *Generator) GenerateTo(prompt string, max int, emit func(piece string) error) error {
+	m := g.Model
+	tokens := g.seed(prompt)
+	if len(tokens) == 0 {
+		return nil
+	}
+	// last is the last token written in the current segment, which the
+	// Detokenizer separates the next tokens from
+	last := ""
+	if visible := withoutMarkers(tokens); len(visible) > 0 {
+		if err := emit(m.detokenize("", visible)); err != nil {
+			return err
+		}
+		last = visible[len(visible)-1]
+	}
+	segments := 1
+	for generated := 0; generated < max; {
+		next, ok := g.next(tokens)
+		if !ok && g.DeadEnd == Restart {
+			if m.Options.Segment != SegmentNone {
+				next, ok = g.next(m.start())
+			} else {
+				next, ok = m.randomNgram(g.Rand)
+			}
+		}
+		nextTokens := m.split(next)
+		if !ok || len(nextTokens) == 0 {
+			break
+		}
+		ended := false
+		if i := indexOf(nextTokens, EOS); i >= 0 {
+			nextTokens, ended = nextTokens[:i], true
+		}
+		if len(nextTokens) > max-generated {
+			nextTokens, ended = nextTokens[:max-generated], false
+		}
+		if len(nextTokens) > 0 {
+			if err := emit(m.detokenize(last, nextTokens)); err != nil {
+				return err
+			}
+			last = nextTokens[len(nextTokens)-1]
+			generated += len(nextTokens)
+		}
+		tokens = append(tokens, nextTokens...)
+		if ended {
+			if segments >= g.segments() {
+				break
+			}
+			segments++
+			tokens, last = m.start(), ""
+			if err := emit(m.Options.Segment.separator()); err != nil {
+				return err
+			}
+		}
+		// Only the last n tokens are needed to sample the next n-gram
+		if len(tokens) > m.Options.N {
+			tokens = tokens[len(tokens)-m.Options.N:]
+		}
+	}
+	return nil
+}
+
+func (g *Generator) segments() int {
+	if g.Segments < 1 {
+		return 1
+	}
+	return g.Segments
+}
+
+// Stream is like GenerateTo, but sends each piece of text to the returned
+// channel, which is closed once generation ends or ctx is done.
+func (g *Generator) Stream(ctx context.Context, prompt string, max int) <-chan string {
+	pieces := make(chan string)
+	go func() {
+		defer close(pieces)
+		g.GenerateTo(prompt, max, func(piece string) error {
+			select {
+			case pieces <- piece:
+				return nil
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		})
+	}()
+	return pieces
+}
+
+// seed returns the tokens of prompt if its last n-gram, or when backing off a
+// shorter context, has next n-grams, and the tokens of a random n-gram, or the
+// start of a segment in a segmented model, if not
+func (g *Generator) seed(prompt string) []string {
+	m := g.Model
+	tokens := m.Tokens(prompt)
+	if m.Options.Segment != SegmentNone {
+		// Prompts continue from the start of a segment
+		tokens = append(m.start(), tokens...)
+		if len(tokens) == m.Options.N {
+			return tokens
+		}
+	}
+	if g.smoothed() && len(tokens) > 0 {
+		return tokens
+	}
+	for k := m.Options.N; k >= 1 && len(tokens) > 0; k-- {
+		if k > len(tokens) || (k < m.Options.N && g.DeadEnd != Backoff) {
+			continue
+		}
+		if m.has(tokens[len(tokens)-k:]) {
+			return tokens
+		}
+	}
+	if m.Options.Segment != SegmentNone {
+		return m.start()
+	}
+	if randNgram, ok := m.randomNgram(g.Rand); ok {
+		return m.split(randNgram)
+	}
+	return nil
+}
+
+// next samples the n-gram, or in TokenMode the token, following tokens. ok is
+// false if tokens are at a dead end.
+func (g *Generator) next(tokens []string) (next string, ok bool) {
+	m := g.Model
+	if g.smoothed() {
+		return m.pickSmoothed(g.Rand, g.Estimator, g.Sampling, tokens)
+	}
+	for k := m.Options.N; k >= 1; k-- {
+		if k > len(tokens) || (k < m.Options.N && g.DeadEnd != Backoff) {
+			continue
+		}
+		if next, ok := m.pick(g.Rand, tokens[len(tokens)-k:], g.Sampling); ok {
+			return next, true
+		}
+	}
+	return "", false
+}
+
+// smoothed reports whether next n-grams are sampled from a smoothed distribution
+func (g *Generator) smoothed() bool {
+	return g.Estimator.Smoothing != MaximumLikelihood
+}
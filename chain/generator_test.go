@@ -0,0 +1,164 @@
+package chain
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestGeneratorDeterministic(t *testing.T) {
+	text := "Hello world! This is a text string to be used during testing. Its short. " +
+		"This text is everything before the end of the test, and the end is near."
+	tests := []struct {
+		name   string
+		opts   Options
+		prompt string
+	}{
+		{"Characters with prompt", Options{N: 2}, "Th"},
+		{"Characters without prompt", Options{N: 1}, ""},
+		{"Words with prompt", Options{N: 1, Words: true}, "the"},
+		{"Words without prompt", Options{N: 1, Lowercase: true, Words: true}, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			model := New(tt.opts)
+			if err := model.Train(strings.NewReader(text)); err != nil {
+				t.Fatalf("Train() error = %v", err)
+			}
+			want := NewGenerator(model, 42).Generate(tt.prompt, 50)
+			for i := 0; i < 10; i++ {
+				// A fresh model rebuilds its samplers, so map ordering can't leak into the output
+				retrained := New(tt.opts)
+				if err := retrained.Train(strings.NewReader(text)); err != nil {
+					t.Fatalf("Train() error = %v", err)
+				}
+				if got := NewGenerator(retrained, 42).Generate(tt.prompt, 50); got != want {
+					t.Fatalf("Generate() = %q, want %q", got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestGeneratorDeadEnd(t *testing.T) {
+	model := New(Options{N: 2, Backoff: true})
+	if err := model.Train(strings.NewReader("abcdefg"), strings.NewReader("xfghij")); err != nil {
+		t.Fatalf("Train() error = %v", err)
+	}
+	tests := []struct {
+		name    string
+		deadEnd DeadEnd
+		prompt  string
+		want    string
+	}{
+		{"Stop", Stop, "ab", "abcdef"},
+		{"Backoff", Backoff, "ab", "abcdefgh"},
+		{"Backoff from unseen prompt", Backoff, "zzf", "zzfgh"},
+		{"Backoff from unseen context", Backoff, "zz", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			generator := NewGenerator(model, 1)
+			generator.DeadEnd = tt.deadEnd
+			got := generator.Generate(tt.prompt, 10)
+			if tt.want == "" {
+				// Unseen prompts start from a random n-gram instead
+				if strings.HasPrefix(got, tt.prompt) {
+					t.Errorf("Generate() = %q, want a random start", got)
+				}
+				return
+			}
+			if got != tt.want {
+				t.Errorf("Generate() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+
+	generator := NewGenerator(model, 1)
+	generator.DeadEnd = Restart
+	got := generator.Generate("ab", 10)
+	if !strings.HasPrefix(got, "abcdef") || len(got) <= len("abcdef") {
+		t.Errorf("Generate() = %q, want to restart after %q", got, "abcdef")
+	}
+}
+
+func TestParseDeadEnd(t *testing.T) {
+	for _, want := range []DeadEnd{Stop, Backoff, Restart} {
+		got, err := ParseDeadEnd(want.String())
+		if err != nil || got != want {
+			t.Errorf("ParseDeadEnd(%q) = %v, %v, want %v", want.String(), got, err, want)
+		}
+	}
+	if _, err := ParseDeadEnd("continue"); err == nil {
+		t.Errorf("ParseDeadEnd() error = nil, want error")
+	}
+}
+
+func TestGeneratorGenerateTo(t *testing.T) {
+	text := "Hello world! This is a text string to be used during testing. Its short. " +
+		"This text is everything before the end of the test, and the end is near."
+	tests := []struct {
+		name   string
+		opts   Options
+		prompt string
+	}{
+		{"Characters", Options{N: 2}, "Th"},
+		{"Words", Options{N: 1, Words: true}, "the"},
+		{"Tokens", Options{N: 2, Mode: TokenMode, Words: true}, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			model := New(tt.opts)
+			if err := model.Train(strings.NewReader(text)); err != nil {
+				t.Fatalf("Train() error = %v", err)
+			}
+			want := NewGenerator(model, 3).Generate(tt.prompt, 40)
+			var pieces []string
+			if err := NewGenerator(model, 3).GenerateTo(tt.prompt, 40, func(piece string) error {
+				pieces = append(pieces, piece)
+				return nil
+			}); err != nil {
+				t.Fatalf("GenerateTo() error = %v", err)
+			}
+			if got := strings.Join(pieces, ""); got != want || len(pieces) < 2 {
+				t.Errorf("GenerateTo() = %q, want %q in several pieces", pieces, want)
+			}
+
+			var streamed []string
+			for piece := range NewGenerator(model, 3).Stream(context.Background(), tt.prompt, 40) {
+				streamed = append(streamed, piece)
+			}
+			if !reflect.DeepEqual(streamed, pieces) {
+				t.Errorf("Stream() = %q, want %q", streamed, pieces)
+			}
+		})
+	}
+}
+
+func TestGeneratorGenerateToStops(t *testing.T) {
+	model := New(Options{N: 1})
+	if err := model.Train(strings.NewReader("abcabcabc")); err != nil {
+		t.Fatalf("Train() error = %v", err)
+	}
+	stop := errors.New("stop")
+	var pieces int
+	err := NewGenerator(model, 1).GenerateTo("a", 100, func(piece string) error {
+		if pieces++; pieces == 3 {
+			return stop
+		}
+		return nil
+	})
+	if err != stop || pieces != 3 {
+		t.Errorf("GenerateTo() = %v after %d pieces, want %v after 3", err, pieces, stop)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	stream := NewGenerator(model, 1).Stream(ctx, "a", 100)
+	<-stream
+	cancel()
+	for range stream {
+		// The stream closes once cancelled, after any piece that was already being sent
+	}
+}
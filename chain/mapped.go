@@ -0,0 +1,604 @@
+package chain
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"math/rand"
+	"os"
+	"sort"
+)
+
+// The mapped model layout holds the counts of a model in fixed-width
+// little-endian arrays that are queried where they lie, so that a memory-mapped
+// model file needs no decoding to load and shares its pages with every process
+// that maps it:
+//
+//	magic       "MRKM"
+//	version     uint32
+//	size        uint32, the size of the header
+//	header      the header and merges of a binary model, as WriteModel writes
+//	            them
+//	tokens      uint64 count, then count+1 uint64 offsets of the text of each
+//	            token, then the text of every token in sorted order
+//	orders      the contexts of n tokens, then if backoff is set, those of
+//	            each length from 1 to n-1
+//
+// and each order is
+//
+//	k           uint64 context length, or 0 if the model doesn't count them
+//	contexts    uint64 count
+//	width       uint64 tokens in each next n-gram
+//	transitions uint64 count
+//	ids         the uint32 token ids of each context, in sorted order
+//	starts      contexts+1 uint64 indexes of the first transition from each
+//	            context
+//	weights     the uint64 cumulative count of each transition, summed over the
+//	            transitions from the same context up to it
+//	next        the uint32 token ids of the next n-gram of each transition
+//
+// Every array after the header starts at a multiple of 8 bytes. Contexts are
+// found by binary search of the ids, and the transitions from each one are
+// ordered by the text of their next n-gram, like a Chooser's, so that sampling
+// searches their weights in place as a Chooser searches its totals.
+const (
+	mappedMagic = "MRKM"
+	// mappedVersion is the version of the mapped model layout
+	mappedVersion = 1
+	// mappedHeaderSize is the size of the magic, version and size that precede
+	// the header of a mapped model
+	mappedHeaderSize = 12
+)
+
+// mappedOrders returns the lengths of the contexts whose transitions a mapped
+// model with opts holds, in the order they are written
+func mappedOrders(opts Options) []int {
+	orders := []int{opts.N}
+	if opts.Backoff {
+		for k := 1; k < opts.N; k++ {
+			orders = append(orders, k)
+		}
+	}
+	return orders
+}
+
+type mappedWriter struct {
+	w   *bufio.Writer
+	at  int64
+	buf [8]byte
+	err error
+}
+
+func (mw *mappedWriter) string(s string) {
+	if mw.err != nil {
+		return
+	}
+	var n int
+	n, mw.err = mw.w.WriteString(s)
+	mw.at += int64(n)
+}
+
+func (mw *mappedWriter) bytes(b []byte) {
+	if mw.err != nil {
+		return
+	}
+	var n int
+	n, mw.err = mw.w.Write(b)
+	mw.at += int64(n)
+}
+
+func (mw *mappedWriter) uint32(x uint32) {
+	binary.LittleEndian.PutUint32(mw.buf[:], x)
+	mw.bytes(mw.buf[:4])
+}
+
+func (mw *mappedWriter) uint64(x uint64) {
+	binary.LittleEndian.PutUint64(mw.buf[:], x)
+	mw.bytes(mw.buf[:])
+}
+
+// align pads the output to a multiple of 8 bytes
+func (mw *mappedWriter) align() {
+	var zeros [8]byte
+	if pad := mw.at % 8; pad != 0 {
+		mw.bytes(zeros[:8-pad])
+	}
+}
+
+// WriteMappedModel writes m to w in the mapped model layout, which OpenModel
+// memory-maps instead of reading. The next n-grams of each context must all
+// have the same number of tokens, as they do in trained models.
+func WriteMappedModel(w io.Writer, m *Model) error {
+	var header bytes.Buffer
+	fw := &formatWriter{w: bufio.NewWriter(&header)}
+	fw.header(m)
+	if fw.err == nil {
+		fw.err = fw.w.Flush()
+	}
+	if fw.err != nil {
+		return fw.err
+	}
+	if header.Len() > math.MaxUint32 {
+		return fmt.Errorf("model error: header of %d bytes is too large to map", header.Len())
+	}
+
+	mw := &mappedWriter{w: bufio.NewWriter(w)}
+	mw.string(mappedMagic)
+	mw.uint32(mappedVersion)
+	mw.uint32(uint32(header.Len()))
+	mw.bytes(header.Bytes())
+	mw.align()
+
+	counts := storeOf(m.counts, m.Options)
+	mw.uint64(uint64(len(counts.tokens)))
+	var offset uint64
+	mw.uint64(offset)
+	for _, token := range counts.tokens {
+		offset += uint64(len(token))
+		mw.uint64(offset)
+	}
+	for _, token := range counts.tokens {
+		mw.string(token)
+	}
+	mw.align()
+	for _, k := range mappedOrders(m.Options) {
+		if err := mw.order(m, counts, k); err != nil {
+			return err
+		}
+	}
+	if mw.err != nil {
+		return mw.err
+	}
+	return mw.w.Flush()
+}
+
+// order writes the transitions of counts from contexts of k tokens
+func (mw *mappedWriter) order(m *Model, counts *store, k int) error {
+	type transition struct {
+		next  []uint32
+		text  string
+		count uint32
+	}
+	var ids []uint32
+	starts := []uint64{0}
+	var weights []uint64
+	var next []uint32
+	// The transitions from each context are gathered and sorted by text
+	var group []transition
+	flush := func() {
+		sort.SliceStable(group, func(i, j int) bool { return group[i].text < group[j].text })
+		var total uint64
+		for _, t := range group {
+			total += uint64(t.count)
+			weights = append(weights, total)
+			next = append(next, t.next...)
+		}
+		starts = append(starts, uint64(len(weights)))
+		group = group[:0]
+	}
+
+	width := -1
+	var err error
+	t := counts.trie(k)
+	if t != nil && len(t.levels) > 0 {
+		var tokens []string
+		t.walk(0, 0, len(t.levels[0].tokens), nil, func(path []uint32, count uint32) {
+			if err != nil {
+				return
+			}
+			if width < 0 {
+				width = len(path) - k
+			}
+			if len(path) < k || len(path)-k != width {
+				err = fmt.Errorf("model error: can't map next n-grams of different lengths")
+				return
+			}
+			if len(ids) == 0 || !equalIDs(ids[len(ids)-k:], path[:k]) {
+				if len(group) > 0 {
+					flush()
+				}
+				ids = append(ids, path[:k]...)
+			}
+			tokens = tokens[:0]
+			for _, id := range path[k:] {
+				tokens = append(tokens, counts.tokens[id])
+			}
+			group = append(group, transition{append([]uint32(nil), path[k:]...), m.join(tokens), count})
+		})
+	}
+	if err != nil {
+		return err
+	}
+	if len(group) > 0 {
+		flush()
+	}
+	if width < 0 {
+		width = 0
+	}
+
+	if t == nil {
+		mw.uint64(0)
+	} else {
+		mw.uint64(uint64(k))
+	}
+	mw.uint64(uint64(len(starts) - 1))
+	mw.uint64(uint64(width))
+	mw.uint64(uint64(len(weights)))
+	for _, id := range ids {
+		mw.uint32(id)
+	}
+	mw.align()
+	for _, start := range starts {
+		mw.uint64(start)
+	}
+	for _, weight := range weights {
+		mw.uint64(weight)
+	}
+	for _, id := range next {
+		mw.uint32(id)
+	}
+	mw.align()
+	return nil
+}
+
+func equalIDs(a, b []uint32) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// mappedReader finds the arrays of a mapped model in data, checking that they
+// fit
+type mappedReader struct {
+	data []byte
+	at   int
+	err  error
+}
+
+// count reads a uint64 count, which may not be larger than data
+func (r *mappedReader) count() uint64 {
+	if r.err != nil {
+		return 0
+	}
+	if len(r.data)-r.at < 8 {
+		r.err = io.ErrUnexpectedEOF
+		return 0
+	}
+	x := binary.LittleEndian.Uint64(r.data[r.at:])
+	r.at += 8
+	if x > uint64(len(r.data)) {
+		r.err = fmt.Errorf("model error: count %d is out of range", x)
+		return 0
+	}
+	return x
+}
+
+// array returns the position of an array of count items of size bytes and
+// skips it
+func (r *mappedReader) array(count uint64, size int) int {
+	if r.err != nil {
+		return 0
+	}
+	if count > uint64(len(r.data)-r.at)/uint64(size) {
+		r.err = io.ErrUnexpectedEOF
+		return 0
+	}
+	at := r.at
+	r.at += int(count) * size
+	return at
+}
+
+// align skips to the next multiple of 8 bytes
+func (r *mappedReader) align() {
+	if r.err != nil {
+		return
+	}
+	r.at = (r.at + 7) &^ 7
+	if r.at > len(r.data) {
+		r.err = io.ErrUnexpectedEOF
+	}
+}
+
+// mappedStore queries the transitions of a mapped model in place. Only the
+// layout of its arrays is checked when it is opened, so ids, offsets and
+// indexes are checked as they are read, and any out of range are read as
+// empty.
+type mappedStore struct {
+	data []byte
+	// tokens is the number of tokens, whose offsets and text start at those
+	// positions in data
+	tokens, offsets, text, textSize int
+	// orders[k-1] holds the transitions from contexts of k tokens, or nil if
+	// they aren't counted
+	orders []*mappedOrder
+}
+
+// mappedOrder holds the number of contexts and transitions in an order, and
+// the positions of its arrays
+type mappedOrder struct {
+	k, width, contexts, transitions int
+	ids, starts, weights, next      int
+}
+
+// openMapped returns the model in data, in the mapped model layout, which
+// it queries in place
+func openMapped(data []byte) (*Model, error) {
+	if len(data) < mappedHeaderSize || string(data[:len(mappedMagic)]) != mappedMagic {
+		return nil, ErrFormat
+	}
+	if version := binary.LittleEndian.Uint32(data[4:]); version != mappedVersion {
+		return nil, fmt.Errorf("model error: unsupported mapped layout version %d", version)
+	}
+	size := binary.LittleEndian.Uint32(data[8:])
+	if uint64(size) > uint64(len(data)-mappedHeaderSize) {
+		return nil, io.ErrUnexpectedEOF
+	}
+	end := mappedHeaderSize + int(size)
+	fr := &formatReader{r: bufio.NewReader(bytes.NewReader(data[mappedHeaderSize:end]))}
+	m, h, err := fr.model()
+	if err != nil {
+		return nil, err
+	}
+
+	r := &mappedReader{data: data, at: end}
+	r.align()
+	s := &mappedStore{data: data, orders: make([]*mappedOrder, h.Options.N)}
+	tokens := r.count()
+	s.tokens = int(tokens)
+	s.offsets = r.array(tokens+1, 8)
+	if r.err == nil {
+		s.textSize = int(binary.LittleEndian.Uint64(data[s.offsets+8*s.tokens:]))
+		s.text = r.array(uint64(s.textSize), 1)
+	}
+	r.align()
+	for _, k := range mappedOrders(h.Options) {
+		o := &mappedOrder{}
+		counted := r.count()
+		contexts, width, transitions := r.count(), r.count(), r.count()
+		if r.err == nil && counted != 0 && counted != uint64(k) {
+			return nil, fmt.Errorf("model error: mapped contexts of %d tokens, want %d", counted, k)
+		}
+		if r.err == nil && width > maxN*2 {
+			return nil, fmt.Errorf("model error: invalid next n-gram length %d", width)
+		}
+		o.k, o.width, o.contexts, o.transitions = int(counted), int(width), int(contexts), int(transitions)
+		o.ids = r.array(contexts*counted, 4)
+		r.align()
+		o.starts = r.array(contexts+1, 8)
+		o.weights = r.array(transitions, 8)
+		o.next = r.array(transitions*width, 4)
+		r.align()
+		if counted != 0 {
+			s.orders[k-1] = o
+		}
+	}
+	if r.err != nil {
+		return nil, r.err
+	}
+	m.counts = s
+	return m, nil
+}
+
+func (s *mappedStore) uint32(at int) uint32 {
+	return binary.LittleEndian.Uint32(s.data[at:])
+}
+
+func (s *mappedStore) uint64(at int) uint64 {
+	return binary.LittleEndian.Uint64(s.data[at:])
+}
+
+// token returns the text of token id where it lies in data
+func (s *mappedStore) token(id uint32) []byte {
+	if int(id) >= s.tokens {
+		return nil
+	}
+	start, end := s.uint64(s.offsets+8*int(id)), s.uint64(s.offsets+8*int(id)+8)
+	if start > end || end > uint64(s.textSize) {
+		return nil
+	}
+	return s.data[s.text+int(start) : s.text+int(end)]
+}
+
+// appendTokens appends the text of the count token ids at position at to
+// tokens
+func (s *mappedStore) appendTokens(tokens []string, at, count int) []string {
+	for j := 0; j < count; j++ {
+		tokens = append(tokens, string(s.token(s.uint32(at+4*j))))
+	}
+	return tokens
+}
+
+// lookup returns the ids of tokens. ok is false if any of them has none.
+func (s *mappedStore) lookup(tokens []string) (ids []uint32, ok bool) {
+	ids = make([]uint32, len(tokens))
+	for i, token := range tokens {
+		j := sort.Search(s.tokens, func(j int) bool { return string(s.token(uint32(j))) >= token })
+		if j == s.tokens || string(s.token(uint32(j))) != token {
+			return nil, false
+		}
+		ids[i] = uint32(j)
+	}
+	return ids, true
+}
+
+func (s *mappedStore) order(k int) *mappedOrder {
+	if k < 1 || k > len(s.orders) {
+		return nil
+	}
+	return s.orders[k-1]
+}
+
+// find returns the index of the context with ids in o
+func (s *mappedStore) find(o *mappedOrder, ids []uint32) (int, bool) {
+	compare := func(i int) int {
+		for j, id := range ids {
+			if x := s.uint32(o.ids + 4*(i*o.k+j)); x != id {
+				if x < id {
+					return -1
+				}
+				return 1
+			}
+		}
+		return 0
+	}
+	i := sort.Search(o.contexts, func(i int) bool { return compare(i) >= 0 })
+	return i, i < o.contexts && compare(i) == 0
+}
+
+// transitions returns the range of the transitions from the ith context of o
+func (s *mappedStore) transitions(o *mappedOrder, i int) (lo, hi int) {
+	start, end := s.uint64(o.starts+8*i), s.uint64(o.starts+8*i+8)
+	if start > end || end > uint64(o.transitions) {
+		return 0, 0
+	}
+	return int(start), int(end)
+}
+
+// each calls fn with the tokens and count of every transition from the ith
+// context of o, appended to prefix
+func (s *mappedStore) each(o *mappedOrder, i int, prefix []string, fn func(path []string, count uint32)) {
+	lo, hi := s.transitions(o, i)
+	path := prefix
+	var prev uint64
+	for j := lo; j < hi; j++ {
+		weight := s.uint64(o.weights + 8*j)
+		path = s.appendTokens(path[:len(prefix)], o.next+4*j*o.width, o.width)
+		fn(path, uint32(weight-prev))
+		prev = weight
+	}
+}
+
+func (s *mappedStore) counted(k int) bool {
+	return s.order(k) != nil
+}
+
+func (s *mappedStore) has(k int, context []string) bool {
+	o := s.order(k)
+	if o == nil || len(context) != k {
+		return false
+	}
+	ids, ok := s.lookup(context)
+	if !ok {
+		return false
+	}
+	i, ok := s.find(o, ids)
+	if !ok {
+		return false
+	}
+	lo, hi := s.transitions(o, i)
+	return lo < hi
+}
+
+func (s *mappedStore) next(context []string, fn func(next []string, count uint32)) {
+	o := s.order(len(context))
+	if o == nil {
+		return
+	}
+	ids, ok := s.lookup(context)
+	if !ok {
+		return
+	}
+	if i, ok := s.find(o, ids); ok {
+		s.each(o, i, nil, fn)
+	}
+}
+
+// pick uses rng to return the next n-gram of context, drawn in proportion to
+// its count by a binary search of the weights where they lie, which picks the
+// same n-gram a Chooser over them would. ok is false if context has none.
+func (s *mappedStore) pick(rng *rand.Rand, context []string) (next []string, ok bool) {
+	o := s.order(len(context))
+	if o == nil {
+		return nil, false
+	}
+	ids, ok := s.lookup(context)
+	if !ok {
+		return nil, false
+	}
+	i, ok := s.find(o, ids)
+	if !ok {
+		return nil, false
+	}
+	lo, hi := s.transitions(o, i)
+	if lo == hi {
+		return nil, false
+	}
+	max := s.uint64(o.weights + 8*(hi-1))
+	if max == 0 {
+		return nil, true
+	}
+	r := uint64(rng.Int63n(int64(max))) + 1
+	j := lo + sort.Search(hi-lo, func(j int) bool { return s.uint64(o.weights+8*(lo+j)) >= r })
+	return s.appendTokens(nil, o.next+4*j*o.width, o.width), true
+}
+
+func (s *mappedStore) walk(k int, fn func(path []string, count uint32)) {
+	o := s.order(k)
+	if o == nil {
+		return
+	}
+	var path []string
+	for i := 0; i < o.contexts; i++ {
+		path = s.appendTokens(path[:0], o.ids+4*i*k, k)
+		s.each(o, i, path, fn)
+	}
+}
+
+func (s *mappedStore) contexts(k int) int {
+	if o := s.order(k); o != nil {
+		return o.contexts
+	}
+	return 0
+}
+
+func (s *mappedStore) context(k, i int) []string {
+	return s.appendTokens(nil, s.order(k).ids+4*i*k, k)
+}
+
+// OpenModel reads the model saved in filename by Save or SaveMapped. Models in
+// the mapped layout are memory-mapped rather than read where the platform
+// supports it, so that they load in constant time and every process that opens
+// the same file shares its memory, and must be closed with Close once they are
+// no longer used.
+func OpenModel(filename string) (*Model, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	magic := make([]byte, len(mappedMagic))
+	if _, err := io.ReadFull(file, magic); err != nil || string(magic) != mappedMagic {
+		if _, err := file.Seek(0, io.SeekStart); err != nil {
+			return nil, err
+		}
+		return ReadModel(file)
+	}
+	info, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+	size := int(info.Size())
+	if int64(size) != info.Size() {
+		return nil, fmt.Errorf("model error: %s is too large to map", filename)
+	}
+	data, err := mapFile(file, size)
+	if err != nil {
+		return nil, err
+	}
+	m, err := openMapped(data)
+	if err != nil {
+		unmapFile(data)
+		return nil, err
+	}
+	m.unmap = func() error { return unmapFile(data) }
+	return m, nil
+}
@@ -0,0 +1,263 @@
+package chain
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestWriteMappedModel(t *testing.T) {
+	text := "Hello world! This is a text string to be used during testing. Its short. Hello again, world."
+	dir, err := ioutil.TempDir("", "markov")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	tests := []struct {
+		name string
+		opts Options
+	}{
+		{"Characters", Options{N: 3}},
+		{"Words", Options{N: 2, Words: true}},
+		{"Backoff", Options{N: 3, Backoff: true}},
+		{"Token mode", Options{N: 2, Mode: TokenMode, Words: true, Backoff: true}},
+		{"Segments", Options{N: 2, Mode: TokenMode, Words: true, Segment: SegmentSentences}},
+		{"BPE tokenizer", Options{N: 2, Tokenizer: "bpe:20"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			model := New(tt.opts)
+			if err := model.Train(strings.NewReader(text)); err != nil {
+				t.Fatalf("Train() error = %v", err)
+			}
+			var buf bytes.Buffer
+			if err := WriteMappedModel(&buf, model); err != nil {
+				t.Fatalf("WriteMappedModel() error = %v", err)
+			}
+			filename := filepath.Join(dir, tt.name+".bin")
+			if err := ioutil.WriteFile(filename, buf.Bytes(), 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			header, err := ReadHeader(bytes.NewReader(buf.Bytes()))
+			if err != nil || !header.Mapped || header.Options != tt.opts || header.Corpus != model.Corpus {
+				t.Errorf("ReadHeader() = %+v, %v, want a mapped header of %+v", header, err, tt.opts)
+			}
+			mapped, err := OpenModel(filename)
+			if err != nil {
+				t.Fatalf("OpenModel() error = %v", err)
+			}
+			defer mapped.Close()
+			read, err := ReadModel(bytes.NewReader(buf.Bytes()))
+			if err != nil {
+				t.Fatalf("ReadModel() error = %v", err)
+			}
+
+			for _, loaded := range []*Model{mapped, read} {
+				if loaded.Options != tt.opts || loaded.Corpus != model.Corpus {
+					t.Errorf("options = %+v %+v, want %+v %+v", loaded.Options, loaded.Corpus, tt.opts, model.Corpus)
+				}
+				for k := 1; k <= tt.opts.N; k++ {
+					if !reflect.DeepEqual(loaded.histogram(k), model.histogram(k)) {
+						t.Errorf("histogram(%d) = %v, want %v", k, loaded.histogram(k), model.histogram(k))
+					}
+				}
+				// Mapped models sample exactly like the model they were written from
+				for _, prompt := range []string{"", "Hello", "zzz"} {
+					if got, want := NewGenerator(loaded, 7).Generate(prompt, 40), NewGenerator(model, 7).Generate(prompt, 40); got != want {
+						t.Errorf("Generate(%q) = %q, want %q", prompt, got, want)
+					}
+					reshaped, want := NewGenerator(loaded, 7), NewGenerator(model, 7)
+					reshaped.Sampling, want.Sampling = Sampling{TopK: 2}, Sampling{TopK: 2}
+					if got, want := reshaped.Generate(prompt, 40), want.Generate(prompt, 40); got != want {
+						t.Errorf("Generate(%q) with top-k = %q, want %q", prompt, got, want)
+					}
+				}
+			}
+			// The mapped model only builds Choosers to reshape the distribution
+			mapped.choosers = newChooserCache()
+			NewGenerator(mapped, 7).Generate("Hello", 40)
+			if n := mapped.choosers.recent.Len(); n != 0 {
+				t.Errorf("mapped model built %d Choosers, want 0", n)
+			}
+
+			// Mapped models can be saved in the binary model format again
+			var saved bytes.Buffer
+			if err := mapped.Save(&saved); err != nil {
+				t.Fatalf("Save() error = %v", err)
+			}
+			if resaved, err := ReadModel(&saved); err != nil || !reflect.DeepEqual(resaved.Histogram(), model.Histogram()) {
+				t.Errorf("ReadModel(Save()) = %v, %v, want %v", resaved.Histogram(), err, model.Histogram())
+			}
+
+			if err := mapped.Close(); err != nil {
+				t.Errorf("Close() error = %v", err)
+			}
+			if hist := mapped.Histogram(); len(hist) != 0 {
+				t.Errorf("Histogram() after Close() = %v, want none", hist)
+			}
+		})
+	}
+}
+
+func TestOpenModelStream(t *testing.T) {
+	dir, err := ioutil.TempDir("", "markov")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	model := New(Options{N: 2})
+	if err := model.Train(strings.NewReader("Hello world!")); err != nil {
+		t.Fatalf("Train() error = %v", err)
+	}
+	var buf bytes.Buffer
+	if err := model.Save(&buf); err != nil {
+		t.Fatal(err)
+	}
+	filename := filepath.Join(dir, "model.bin")
+	if err := ioutil.WriteFile(filename, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := OpenModel(filename)
+	if err != nil {
+		t.Fatalf("OpenModel() error = %v", err)
+	}
+	if !reflect.DeepEqual(loaded.Histogram(), model.Histogram()) {
+		t.Errorf("OpenModel() = %v, want %v", loaded.Histogram(), model.Histogram())
+	}
+	if err := loaded.Close(); err != nil {
+		t.Errorf("Close() error = %v", err)
+	}
+}
+
+func TestUpdateMappedModel(t *testing.T) {
+	dir, err := ioutil.TempDir("", "markov")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	opts := Options{N: 2, Mode: TokenMode, Backoff: true}
+	model := New(opts)
+	if err := model.Train(strings.NewReader("Hello world!")); err != nil {
+		t.Fatalf("Train() error = %v", err)
+	}
+	var buf bytes.Buffer
+	if err := model.SaveMapped(&buf); err != nil {
+		t.Fatalf("SaveMapped() error = %v", err)
+	}
+	filename := filepath.Join(dir, "model.bin")
+	if err := ioutil.WriteFile(filename, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	mapped, err := OpenModel(filename)
+	if err != nil {
+		t.Fatalf("OpenModel() error = %v", err)
+	}
+	if err := mapped.Update(strings.NewReader("Hello again")); err != nil {
+		t.Fatalf("Update() error = %v", err)
+	}
+	want := New(opts)
+	if err := want.Train(strings.NewReader("Hello world!"), strings.NewReader("Hello again")); err != nil {
+		t.Fatalf("Train() error = %v", err)
+	}
+	// The updated model is held in memory, so it outlives the mapping
+	if err := mapped.Close(); err != nil {
+		t.Errorf("Close() error = %v", err)
+	}
+	for k := 1; k <= opts.N; k++ {
+		if got := mapped.histogram(k); !reflect.DeepEqual(got, want.histogram(k)) {
+			t.Errorf("Update() histogram(%d) = %v, want %v", k, got, want.histogram(k))
+		}
+	}
+}
+
+func TestReplaceMappedModel(t *testing.T) {
+	dir, err := ioutil.TempDir("", "markov")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	opts := Options{N: 1, Mode: TokenMode}
+	model := New(opts)
+	if err := model.Train(strings.NewReader("Hello world!")); err != nil {
+		t.Fatalf("Train() error = %v", err)
+	}
+	var mapped bytes.Buffer
+	if err := model.SaveMapped(&mapped); err != nil {
+		t.Fatalf("SaveMapped() error = %v", err)
+	}
+	filename := filepath.Join(dir, "model.bin")
+	if err := ioutil.WriteFile(filename, mapped.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	other := New(opts)
+	if err := other.Train(strings.NewReader("abc")); err != nil {
+		t.Fatalf("Train() error = %v", err)
+	}
+	var saved bytes.Buffer
+	if err := other.Save(&saved); err != nil {
+		t.Fatalf("Save() error = %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		replace func(m *Model) error
+		want    StringHistogram
+	}{
+		{"Load", func(m *Model) error { return m.Load(bytes.NewReader(saved.Bytes())) }, other.Histogram()},
+		{"ImportJSON", func(m *Model) error { return m.ImportJSON(strings.NewReader(`{"a":{"b":2}}`)) }, StringHistogram{"a": {"b": 2}}},
+		{"Train", func(m *Model) error { return m.Train(strings.NewReader("abc")) }, other.Histogram()},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, err := OpenModel(filename)
+			if err != nil {
+				t.Fatalf("OpenModel() error = %v", err)
+			}
+			// A model that fails to load stays mapped
+			if err := m.Load(strings.NewReader("not a model")); err == nil || m.unmap == nil {
+				t.Errorf("Load() error = %v, want an error that leaves the model mapped", err)
+			}
+			if err := tt.replace(m); err != nil {
+				t.Fatalf("%s() error = %v", tt.name, err)
+			}
+			if m.unmap != nil {
+				t.Errorf("%s() left the model mapped", tt.name)
+			}
+			if got := m.Histogram(); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("%s() = %v, want %v", tt.name, got, tt.want)
+			}
+			if err := m.Close(); err != nil {
+				t.Errorf("Close() error = %v", err)
+			}
+		})
+	}
+}
+
+func TestOpenMappedErrors(t *testing.T) {
+	model := New(Options{N: 2, Backoff: true})
+	if err := model.Train(strings.NewReader("Hello world!")); err != nil {
+		t.Fatalf("Train() error = %v", err)
+	}
+	var buf bytes.Buffer
+	if err := model.SaveMapped(&buf); err != nil {
+		t.Fatalf("SaveMapped() error = %v", err)
+	}
+	valid := buf.Bytes()
+
+	for size := 0; size < len(valid); size++ {
+		if _, err := openMapped(valid[:size]); err == nil {
+			t.Errorf("openMapped() of %d of %d bytes error = nil, want error", size, len(valid))
+		}
+	}
+	version := append([]byte(nil), valid...)
+	version[4] = 9
+	if _, err := openMapped(version); err == nil {
+		t.Errorf("openMapped() of version 9 error = nil, want error")
+	}
+}
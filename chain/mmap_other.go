@@ -0,0 +1,24 @@
+//go:build !darwin && !dragonfly && !freebsd && !linux && !netbsd && !openbsd
+// +build !darwin,!dragonfly,!freebsd,!linux,!netbsd,!openbsd
+
+package chain
+
+import (
+	"io"
+	"os"
+)
+
+// mapFile reads the size bytes of file into memory, on platforms where it
+// can't be mapped
+func mapFile(file *os.File, size int) ([]byte, error) {
+	data := make([]byte, size)
+	if _, err := io.ReadFull(io.NewSectionReader(file, 0, int64(size)), data); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// unmapFile releases data returned by mapFile
+func unmapFile(data []byte) error {
+	return nil
+}
@@ -0,0 +1,538 @@
+package chain
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Mode is how a Model steps from one n-gram to the next.
+type Mode int
+
+const (
+	// NgramMode predicts the next n tokens from the last n tokens, so generated
+	// text advances a whole n-gram at a time.
+	NgramMode Mode = iota
+	// TokenMode predicts the single next token from the last n tokens, like a
+	// classic order-n markov chain.
+	TokenMode
+)
+
+var modeNames = []string{"ngram", "token"}
+
+func (mode Mode) String() string {
+	if mode < 0 || int(mode) >= len(modeNames) {
+		return fmt.Sprintf("Mode(%d)", int(mode))
+	}
+	return modeNames[mode]
+}
+
+// ParseMode returns the Mode named name: "ngram" or "token"
+func ParseMode(name string) (Mode, error) {
+	for mode, modeName := range modeNames {
+		if name == modeName {
+			return Mode(mode), nil
+		}
+	}
+	return NgramMode, fmt.Errorf("unknown mode %q, must be one of %s", name, strings.Join(modeNames, ", "))
+}
+
+// Options configures how a Model splits and counts its training text.
+type Options struct {
+	// N is the number of tokens in each n-gram.
+	N int
+	// Mode is how the model steps from one n-gram to the next.
+	Mode Mode
+	// Lowercase converts text to lowercase before it is counted.
+	Lowercase bool
+	// Words uses word-level n-grams instead of character-level n-grams. It is
+	// short for the "words" Tokenizer.
+	Words bool
+	// Backoff also counts the transitions from every shorter context of 1 to N-1
+	// tokens, so that generation can back off to them when an n-gram has no
+	// next n-grams.
+	Backoff bool
+	// Segment splits the text into segments that are each padded with BOS and
+	// EOS markers, so the model learns how they start and end.
+	Segment Segment
+	// Tokenizer names the Tokenizer that splits the text into tokens, as
+	// accepted by ParseTokenizer. If it is empty, text is split into words if
+	// Words is set and into runes if not.
+	Tokenizer string
+	// Detokenizer names the Detokenizer that joins generated tokens back into
+	// text, as accepted by ParseDetokenizer. If it is empty, the one paired with
+	// the Tokenizer is used.
+	Detokenizer string
+	// Normalization is the Unicode normalization form text is converted to
+	// before it is split into tokens.
+	Normalization Normalization
+	// CaseFold folds text with full Unicode case folding before it is split
+	// into tokens, so that e.g. "Straße" and "STRASSE" are the same tokens.
+	// Unlike Lowercase, it also applies to prompts before they are split.
+	CaseFold bool
+	// StripAccents removes accents and other combining marks from text.
+	StripAccents bool
+	// StripControl removes control characters other than whitespace from text.
+	StripControl bool
+}
+
+// Model is a markov chain trained on an n-gram frequency histogram.
+// Once trained or loaded, a Model is safe for concurrent use by any number of
+// Generators. Train, Load and ImportJSON must not be called concurrently with
+// any other method.
+type Model struct {
+	Options Options
+	// Corpus identifies the text the model was trained on.
+	Corpus Corpus
+	// MemoryLimit is the most memory, in bytes, that Train may use to count the
+	// corpus, or 0 for no limit. Train returns an error wrapping ErrMemoryLimit
+	// if counting would need more.
+	MemoryLimit int64
+	// Jobs is the number of goroutines that Train counts the corpus on. If it
+	// is more than 1, documents are split into shards that are counted in
+	// parallel, and their counts merged into the same model that counting on
+	// one goroutine builds.
+	Jobs int
+	// counts holds the transitions from contexts of N tokens, and from every
+	// shorter context if Options.Backoff is set
+	counts counter
+	// unmap releases the memory mapping of a model opened by OpenModel
+	unmap func() error
+	// ChooserCacheSize is the most Choosers over the next n-grams of contexts
+	// that generation keeps for reuse, or DefaultChooserCacheSize if it is 0.
+	// Each Chooser is built the first time its context is sampled from.
+	ChooserCacheSize int
+	choosers         *chooserCache
+	// tables and kneserNeyTables hold the frequencies used by Estimators
+	tables, kneserNeyTables *smoothingTables
+	// mu guards the tables, which are built lazily
+	mu sync.Mutex
+	// tokenizer and detokenizer are chosen by Options
+	tokenizer   Tokenizer
+	detokenizer Detokenizer
+}
+
+// Corpus identifies the text a model was trained on.
+type Corpus struct {
+	// Hash is the hex-encoded SHA-256 hash of the training text. It is set by Train.
+	Hash string
+	// Fingerprint identifies the training inputs without reading them, e.g. by
+	// their names, sizes and modification times. It is set by the caller.
+	Fingerprint string
+}
+
+// HashCorpus returns the Corpus.Hash that Train would record for readers. The
+// hash is of the text as read, before it is decompressed.
+func HashCorpus(readers ...io.Reader) (string, error) {
+	hash := newCorpusHash()
+	for _, r := range readers {
+		if _, err := io.Copy(ioutil.Discard, hash.add(r)); err != nil {
+			return "", err
+		}
+	}
+	return hash.sum(), nil
+}
+
+// corpusHash hashes each reader of a corpus separately. The hash of a single
+// reader is the SHA-256 of its text, and the hash of several readers is the
+// SHA-256 of their hashes, so that moving text between readers changes the hash.
+type corpusHash struct {
+	hashes []hash.Hash
+}
+
+func newCorpusHash() *corpusHash {
+	return &corpusHash{}
+}
+
+// add returns a reader that hashes the text read from r
+func (c *corpusHash) add(r io.Reader) io.Reader {
+	h := sha256.New()
+	c.hashes = append(c.hashes, h)
+	return io.TeeReader(r, h)
+}
+
+func (c *corpusHash) sum() string {
+	if len(c.hashes) == 1 {
+		return hex.EncodeToString(c.hashes[0].Sum(nil))
+	}
+	combined := sha256.New()
+	for _, h := range c.hashes {
+		combined.Write(h.Sum(nil))
+	}
+	return hex.EncodeToString(combined.Sum(nil))
+}
+
+// New returns an empty Model configured with opts.
+func New(opts Options) *Model {
+	m := &Model{Options: opts, counts: emptyStore(opts), choosers: newChooserCache()}
+	m.setTokenizers()
+	return m
+}
+
+// setTokenizers chooses the model's Tokenizer and Detokenizer from its Options.
+// If they are invalid, runes or words are used as if they were left empty.
+func (m *Model) setTokenizers() error {
+	tokenizer, detokenizer, err := m.Options.tokenizers()
+	if err != nil {
+		tokenizer, detokenizer, _ = Options{Words: m.Options.Words}.tokenizers()
+	}
+	m.tokenizer, m.detokenizer = tokenizer, detokenizer
+	return err
+}
+
+// Tokenizer returns the Tokenizer that splits the model's text into tokens.
+func (m *Model) Tokenizer() Tokenizer {
+	return m.tokenizer
+}
+
+// Histogram returns the transitions of the model as an n-gram frequency
+// histogram. It is built from the model's counts on every call, and takes many
+// times more memory than they do.
+func (m *Model) Histogram() StringHistogram {
+	return m.histogram(m.Options.N)
+}
+
+// Train replaces the model's histogram with one built from the text read from
+// each of readers. Readers are read with ReadCorpus, so they may be compressed
+// or tar archives. Each document is counted separately, so no transitions span
+// the end of one document and the start of the next.
+func (m *Model) Train(readers ...io.Reader) error {
+	if err := m.setTokenizers(); err != nil {
+		return err
+	}
+	counts := newStore(m.Options, m.MemoryLimit)
+	hash := newCorpusHash()
+	if err := m.count(counts, hash, true, readers); err != nil {
+		return err
+	}
+	counts.freeze()
+	err := m.release()
+	m.counts = counts
+	m.Corpus = Corpus{Hash: hash.sum()}
+	m.choosers = newChooserCache()
+	m.tables, m.kneserNeyTables = nil, nil
+	return err
+}
+
+// Update adds the transitions in the text read from each of readers to the
+// model's histogram, as if the model had been trained on them too. The text is
+// split and counted with the model's Options and Tokenizer, which isn't learned
+// again, so a "bpe" model splits it with the merges learned from its corpus.
+// The model's Corpus.Hash becomes the SHA-256 of its previous hash and that of
+// readers, and a model opened by OpenModel is read into memory and unmapped.
+func (m *Model) Update(readers ...io.Reader) error {
+	if _, _, err := m.Options.tokenizers(); err != nil {
+		return err
+	}
+	counts, err := thaw(m.counts, m.Options, m.MemoryLimit)
+	if err != nil {
+		return err
+	}
+	hash := newCorpusHash()
+	if err := m.count(counts, hash, false, readers); err != nil {
+		return err
+	}
+	counts.freeze()
+	err = m.release()
+	m.counts = counts
+	updated := sha256.Sum256([]byte(m.Corpus.Hash + hash.sum()))
+	m.Corpus = Corpus{Hash: hex.EncodeToString(updated[:])}
+	m.choosers = newChooserCache()
+	m.tables, m.kneserNeyTables = nil, nil
+	return err
+}
+
+// count adds the transitions in the text read from readers to counts, and
+// hashes the text with hash. If learn is set, a Tokenizer that learns from the
+// corpus learns from all of the text before it is counted.
+func (m *Model) count(counts *store, hash *corpusHash, learn bool, readers []io.Reader) error {
+	// Shards are counted with the tokenizer that counting starts with, after
+	// any learning
+	var sharded *shardCounter
+	defer func() {
+		if sharded != nil {
+			sharded.close()
+		}
+	}()
+	countDoc := func(doc io.Reader) error {
+		if m.Jobs > 1 {
+			if sharded == nil {
+				sharded = newShardCounter(m.Options, m.tokenizer, counts, m.Jobs)
+			}
+			return sharded.count(doc)
+		}
+		return countTransitions(doc, m.Options, m.tokenizer, counts.addWindow)
+	}
+	count := func(doc io.Reader) error {
+		return countDoc(m.Options.normalizeReader(doc))
+	}
+	// Tokenizers that learn from the corpus have to read all of it first
+	learner, learns := m.tokenizer.(learner)
+	learns = learns && learn
+	var docs [][]byte
+	if learns {
+		count = func(doc io.Reader) error {
+			b, err := ioutil.ReadAll(m.Options.normalizeReader(doc))
+			docs = append(docs, b)
+			return err
+		}
+	}
+	for _, r := range readers {
+		hashed := hash.add(r)
+		err := ReadCorpus(hashed, count)
+		if err != nil {
+			return err
+		}
+		// Archives may end with padding that was never read, but is still hashed
+		if _, err := io.Copy(ioutil.Discard, hashed); err != nil {
+			return err
+		}
+	}
+	if learns {
+		m.tokenizer = learner.learn(docs)
+		for _, doc := range docs {
+			if err := countDoc(bytes.NewReader(doc)); err != nil {
+				return err
+			}
+		}
+	}
+	if sharded != nil {
+		return sharded.close()
+	}
+	return nil
+}
+
+// Generate continues prompt by sampling up to max tokens from the model using a
+// randomly seeded Generator. Use NewGenerator for reproducible output.
+func (m *Model) Generate(prompt string, max int) string {
+	return NewGenerator(m, time.Now().UTC().UnixNano()).Generate(prompt, max)
+}
+
+// histogram builds the histogram of contexts of k tokens, or returns nil if
+// the model has none
+func (m *Model) histogram(k int) StringHistogram {
+	if !m.counts.counted(k) {
+		return nil
+	}
+	hist := make(StringHistogram)
+	m.counts.walk(k, func(path []string, count uint32) {
+		gram := m.join(path[:k])
+		if _, ok := hist[gram]; !ok {
+			hist[gram] = make(map[string]uint32)
+		}
+		hist[gram][m.join(path[k:])] = count
+	})
+	return hist
+}
+
+// has reports whether context, the last k tokens, has any next n-grams
+func (m *Model) has(context []string) bool {
+	return m.counts.has(len(context), context)
+}
+
+// randomNgram uses rng to choose an n-gram that has next n-grams. ok is false
+// if the model has none.
+func (m *Model) randomNgram(rng *rand.Rand) (ngram string, ok bool) {
+	contexts := m.counts.contexts(m.Options.N)
+	if contexts == 0 {
+		return "", false
+	}
+	return m.join(m.counts.context(m.Options.N, rng.Intn(contexts))), true
+}
+
+// storeHistogram adds the transitions of hist, from contexts of k tokens, to
+// counts
+func (m *Model) storeHistogram(counts *store, k int, hist StringHistogram) error {
+	for gram, nextGrams := range hist {
+		context := m.split(gram)
+		if len(context) != k {
+			return fmt.Errorf("model error: n-gram %q doesn't have %d tokens", gram, k)
+		}
+		for nextGram, frequency := range nextGrams {
+			if err := counts.add(k, append(context[:k:k], m.split(nextGram)...), frequency); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// pick uses rng to return the next n-gram of context, drawn from its
+// distribution reshaped by s. ok is false if context has none. Mapped models
+// are sampled in place when s is proportional, without building a Chooser.
+func (m *Model) pick(rng *rand.Rand, context []string, s Sampling) (next string, ok bool) {
+	if mapped, isMapped := m.counts.(*mappedStore); isMapped && s.isProportional() {
+		tokens, ok := mapped.pick(rng, context)
+		return m.join(tokens), ok
+	}
+	if chooser := m.chooser(context); chooser != nil {
+		return chooser.PickWith(rng, s), true
+	}
+	return "", false
+}
+
+// chooser returns the Chooser over the next n-grams of context, or nil if it
+// has none
+func (m *Model) chooser(context []string) *Chooser {
+	key := chooserKey{len(context), m.join(context)}
+	if chooser, ok := m.choosers.get(key); ok {
+		return chooser
+	}
+	// Choosers are built outside the cache's lock, so concurrent Generators
+	// may both build the same one, but never wait for each other to build one.
+	//
+	// Next n-grams usually come in sorted order already, as they do from
+	// mapped models, and only need sorting if not
+	var items []string
+	var totals []uint64
+	var total uint64
+	sorted := true
+	m.counts.next(context, func(next []string, count uint32) {
+		item := m.join(next)
+		if len(items) > 0 && item <= items[len(items)-1] {
+			sorted = false
+		}
+		total += uint64(count)
+		items = append(items, item)
+		totals = append(totals, total)
+	})
+	var chooser *Chooser
+	if !sorted {
+		frequencies := make(map[string]uint32, len(items))
+		var prev uint64
+		for i, item := range items {
+			frequencies[item] = uint32(totals[i] - prev)
+			prev = totals[i]
+		}
+		chooser = NewChooser(frequencies)
+	} else if len(items) > 0 {
+		chooser = &Chooser{items: items, totals: totals, max: total}
+	}
+	size := m.ChooserCacheSize
+	if size <= 0 {
+		size = DefaultChooserCacheSize
+	}
+	m.choosers.add(key, chooser, size)
+	return chooser
+}
+
+// split splits an n-gram of the model's histogram into its tokens
+func (m *Model) split(text string) []string {
+	if text == "" {
+		return nil
+	}
+	if separator := m.tokenizer.Separator(); separator != "" {
+		return strings.Split(text, separator)
+	}
+	return splitTokens(m.tokenizer.Split, text)
+}
+
+// join joins tokens into an n-gram of the model's histogram
+func (m *Model) join(tokens []string) string {
+	return strings.Join(tokens, m.tokenizer.Separator())
+}
+
+// detokenize joins tokens back into text as they would follow prev, the last
+// token written before them, if it isn't empty
+func (m *Model) detokenize(prev string, tokens []string) string {
+	var text strings.Builder
+	for _, token := range tokens {
+		if prev != "" {
+			text.WriteString(m.detokenizer.Separator(prev, token))
+		}
+		text.WriteString(token)
+		prev = token
+	}
+	return text.String()
+}
+
+// Save writes the model to w in the binary model format.
+func (m *Model) Save(w io.Writer) error {
+	return WriteModel(w, m)
+}
+
+// SaveMapped writes the model to w in the mapped model layout, which OpenModel
+// memory-maps instead of reading.
+func (m *Model) SaveMapped(w io.Writer) error {
+	return WriteMappedModel(w, m)
+}
+
+// Close releases the memory mapping of a model opened by OpenModel, after which
+// the model has no transitions. It does nothing if the model isn't mapped.
+func (m *Model) Close() error {
+	if m.unmap == nil {
+		return nil
+	}
+	err := m.release()
+	m.counts = emptyStore(m.Options)
+	m.choosers = newChooserCache()
+	m.tables, m.kneserNeyTables = nil, nil
+	return err
+}
+
+// release releases the memory mapping of a model opened by OpenModel, if it is
+// mapped, before its counts are replaced
+func (m *Model) release() error {
+	if m.unmap == nil {
+		return nil
+	}
+	err := m.unmap()
+	m.unmap = nil
+	return err
+}
+
+// Load replaces the model with one previously written by Save, including the
+// Options it was trained with.
+func (m *Model) Load(r io.Reader) error {
+	loaded, err := ReadModel(r)
+	if err != nil {
+		return err
+	}
+	err = m.release()
+	m.Options = loaded.Options
+	m.Corpus = loaded.Corpus
+	m.counts = loaded.counts
+	m.tokenizer, m.detokenizer = loaded.tokenizer, loaded.detokenizer
+	m.choosers = newChooserCache()
+	m.tables, m.kneserNeyTables = nil, nil
+	return err
+}
+
+// ExportJSON writes the model's histogram to w as JSON.
+func (m *Model) ExportJSON(w io.Writer) error {
+	return json.NewEncoder(w).Encode(m.Histogram())
+}
+
+// ImportJSON replaces the model's histogram with one previously written by
+// ExportJSON. The histogram must have been built with the same Options as m.
+// JSON histograms have no backoff histograms.
+func (m *Model) ImportJSON(r io.Reader) error {
+	b, err := ioutil.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	hist := make(StringHistogram)
+	if err := json.Unmarshal(b, &hist); err != nil {
+		return err
+	}
+	counts := newStore(Options{N: m.Options.N, Mode: m.Options.Mode}, 0)
+	if err := m.storeHistogram(counts, m.Options.N, hist); err != nil {
+		return err
+	}
+	counts.freeze()
+	err = m.release()
+	m.counts = counts
+	m.Corpus = Corpus{}
+	m.choosers = newChooserCache()
+	m.tables, m.kneserNeyTables = nil, nil
+	return err
+}
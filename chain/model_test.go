@@ -0,0 +1,236 @@
+package chain
+
+import (
+	"bytes"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestModelSaveLoad(t *testing.T) {
+	text := "Hello world! This is a text string to be used during testing. Its short."
+	tests := []struct {
+		name string
+		opts Options
+	}{
+		{"Characters", Options{N: 3}},
+		{"Lowercase characters", Options{N: 2, Lowercase: true}},
+		{"Words", Options{N: 1, Words: true}},
+		{"Lowercase words", Options{N: 2, Lowercase: true, Words: true}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			model := New(tt.opts)
+			if err := model.Train(strings.NewReader(text)); err != nil {
+				t.Fatalf("Train() error = %v", err)
+			}
+			var buf bytes.Buffer
+			if err := model.Save(&buf); err != nil {
+				t.Fatalf("Save() error = %v", err)
+			}
+			loaded := New(tt.opts)
+			if err := loaded.Load(&buf); err != nil {
+				t.Fatalf("Load() error = %v", err)
+			}
+			if !reflect.DeepEqual(loaded.Histogram(), model.Histogram()) {
+				t.Errorf("Load() = %v, want %v", loaded.Histogram(), model.Histogram())
+			}
+		})
+	}
+}
+
+func TestModelGenerate(t *testing.T) {
+	tests := []struct {
+		name   string
+		text   string
+		opts   Options
+		prompt string
+		max    int
+		want   string
+	}{
+		// Each n-gram has exactly one successor, so generation is deterministic
+		{"Characters", "abcdefgh", Options{N: 2}, "ab", 2, "abcd"},
+		{"Truncated n-gram", "abcdefgh", Options{N: 2}, "ab", 3, "abcde"},
+		{"Token mode", "abcdefgh", Options{N: 2, Mode: TokenMode}, "ab", 3, "abcde"},
+		{"Token mode dead end", "abcdefgh", Options{N: 2, Mode: TokenMode}, "ab", 100, "abcdefgh"},
+		{"Token mode words", "one two three four five", Options{N: 2, Mode: TokenMode, Words: true}, "one two", 100, "one two three four five"},
+		{"Words", "one two three four five", Options{N: 1, Words: true}, "one", 3, "one two three four"},
+		{"Dead end", "abcdefgh", Options{N: 2}, "ab", 100, "abcdef"},
+		{"Empty model", "", Options{N: 2}, "ab", 10, ""},
+		{"Segment", "abcdefgh", Options{N: 2, Segment: SegmentDocuments}, "", 100, "abcdefgh"},
+		{"Segment prompt", "one two three. four five.", Options{N: 1, Mode: TokenMode, Words: true, Segment: SegmentSentences}, "four", 100, "four five."},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			model := New(tt.opts)
+			if err := model.Train(strings.NewReader(tt.text)); err != nil {
+				t.Fatalf("Train() error = %v", err)
+			}
+			if got := model.Generate(tt.prompt, tt.max); got != tt.want {
+				t.Errorf("Generate() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestModelTrainMultipleReaders(t *testing.T) {
+	model := New(Options{N: 1})
+	if err := model.Train(strings.NewReader("abcd"), strings.NewReader("wxyz")); err != nil {
+		t.Fatalf("Train() error = %v", err)
+	}
+	want := StringHistogram{"a": {"b": 1}, "b": {"c": 1}, "w": {"x": 1}, "x": {"y": 1}}
+	if !reflect.DeepEqual(model.Histogram(), want) {
+		t.Errorf("Train() = %v, want %v", model.Histogram(), want)
+	}
+
+	hash, err := HashCorpus(strings.NewReader("abcd"), strings.NewReader("wxyz"))
+	if err != nil {
+		t.Fatalf("HashCorpus() error = %v", err)
+	}
+	if hash != model.Corpus.Hash {
+		t.Errorf("HashCorpus() = %v, want %v", hash, model.Corpus.Hash)
+	}
+	moved, _ := HashCorpus(strings.NewReader("abc"), strings.NewReader("dwxyz"))
+	single, _ := HashCorpus(strings.NewReader("abcdwxyz"))
+	if moved == hash || single == hash {
+		t.Errorf("HashCorpus() = %v for different readers, want a different hash", hash)
+	}
+}
+
+func TestModelTrainBackoff(t *testing.T) {
+	model := New(Options{N: 2, Lowercase: true, Backoff: true})
+	if err := model.Train(strings.NewReader("ABCDEF")); err != nil {
+		t.Fatalf("Train() error = %v", err)
+	}
+	want := StringHistogram{"ab": {"cd": 1}, "bc": {"de": 1}}
+	if !reflect.DeepEqual(model.Histogram(), want) {
+		t.Errorf("Train() = %v, want %v", model.Histogram(), want)
+	}
+	wantBackoff := StringHistogram{"b": {"cd": 1}, "c": {"de": 1}}
+	if !reflect.DeepEqual(model.histogram(1), wantBackoff) {
+		t.Errorf("Train() backoff = %v, want %v", model.histogram(1), wantBackoff)
+	}
+}
+
+func TestModelTrainTokenMode(t *testing.T) {
+	model := New(Options{N: 2, Mode: TokenMode, Backoff: true})
+	if err := model.Train(strings.NewReader("abcab")); err != nil {
+		t.Fatalf("Train() error = %v", err)
+	}
+	want := StringHistogram{"ab": {"c": 1}, "bc": {"a": 1}, "ca": {"b": 1}}
+	if !reflect.DeepEqual(model.Histogram(), want) {
+		t.Errorf("Train() = %v, want %v", model.Histogram(), want)
+	}
+	wantBackoff := StringHistogram{"b": {"c": 1}, "c": {"a": 1}, "a": {"b": 1}}
+	if !reflect.DeepEqual(model.histogram(1), wantBackoff) {
+		t.Errorf("Train() backoff = %v, want %v", model.histogram(1), wantBackoff)
+	}
+}
+
+func TestModelUpdate(t *testing.T) {
+	old := "Hello world! This is a text string to be used during testing."
+	text := "Hello again, world. Its short.\nAnd it has a second line."
+	tests := []struct {
+		name string
+		opts Options
+		jobs int
+	}{
+		{"Characters", Options{N: 3}, 0},
+		{"Words", Options{N: 2, Words: true, Lowercase: true}, 0},
+		{"Backoff", Options{N: 3, Backoff: true}, 0},
+		{"Token mode", Options{N: 2, Mode: TokenMode, Words: true, Backoff: true}, 0},
+		{"Segments", Options{N: 2, Words: true, Segment: SegmentSentences}, 0},
+		{"Jobs", Options{N: 2, Mode: TokenMode, Backoff: true}, 4},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			want := New(tt.opts)
+			if err := want.Train(strings.NewReader(old), strings.NewReader(text)); err != nil {
+				t.Fatalf("Train() error = %v", err)
+			}
+			model := New(tt.opts)
+			if err := model.Train(strings.NewReader(old)); err != nil {
+				t.Fatalf("Train() error = %v", err)
+			}
+			trained := model.Corpus
+			// Models read back from a file are updated like the one trained
+			var buf bytes.Buffer
+			if err := model.Save(&buf); err != nil {
+				t.Fatalf("Save() error = %v", err)
+			}
+			loaded, err := ReadModel(&buf)
+			if err != nil {
+				t.Fatalf("ReadModel() error = %v", err)
+			}
+			for _, m := range []*Model{model, loaded} {
+				m.Jobs = tt.jobs
+				if err := m.Update(strings.NewReader(text)); err != nil {
+					t.Fatalf("Update() error = %v", err)
+				}
+				for k := 1; k <= tt.opts.N; k++ {
+					if got := m.histogram(k); !reflect.DeepEqual(got, want.histogram(k)) {
+						t.Errorf("Update() histogram(%d) = %v, want %v", k, got, want.histogram(k))
+					}
+				}
+				if m.Corpus.Hash == "" || m.Corpus == trained || m.Corpus == want.Corpus {
+					t.Errorf("Update() corpus = %+v, want a new hash", m.Corpus)
+				}
+			}
+			if model.Corpus != loaded.Corpus {
+				t.Errorf("Update() corpus = %+v, want %+v", loaded.Corpus, model.Corpus)
+			}
+		})
+	}
+
+	// Histograms imported from JSON have no backoff histograms to update
+	imported := New(Options{N: 2, Backoff: true})
+	if err := imported.ImportJSON(strings.NewReader(`{"ab":{"cd":1}}`)); err != nil {
+		t.Fatalf("ImportJSON() error = %v", err)
+	}
+	if err := imported.Update(strings.NewReader("abcdef")); err != nil {
+		t.Fatalf("Update() error = %v", err)
+	}
+	wantImported := StringHistogram{"ab": {"cd": 2}, "bc": {"de": 1}}
+	if got := imported.Histogram(); !reflect.DeepEqual(got, wantImported) || imported.histogram(1) != nil {
+		t.Errorf("Update() = %v and backoff %v, want %v and no backoff", got, imported.histogram(1), wantImported)
+	}
+
+	// The merges learned from the corpus split the new text
+	bpe := New(Options{N: 1, Mode: TokenMode, Tokenizer: "bpe:5"})
+	if err := bpe.Train(strings.NewReader(old)); err != nil {
+		t.Fatalf("Train() error = %v", err)
+	}
+	tokenizer := bpe.Tokenizer()
+	if err := bpe.Update(strings.NewReader("an entirely different text")); err != nil {
+		t.Fatalf("Update() error = %v", err)
+	}
+	if !reflect.DeepEqual(bpe.Tokenizer(), tokenizer) {
+		t.Errorf("Update() learned the tokenizer again, want the merges learned by Train()")
+	}
+
+	limited := New(Options{N: 3, Backoff: true})
+	if err := limited.Train(strings.NewReader(old)); err != nil {
+		t.Fatalf("Train() error = %v", err)
+	}
+	before := limited.Histogram()
+	limited.MemoryLimit = 1 << 10
+	if err := limited.Update(strings.NewReader(strings.Repeat(text, 10))); !errors.Is(err, ErrMemoryLimit) {
+		t.Errorf("Update() error = %v, want %v", err, ErrMemoryLimit)
+	}
+	if !reflect.DeepEqual(limited.Histogram(), before) {
+		t.Errorf("Update() that failed changed the model")
+	}
+}
+
+func TestParseMode(t *testing.T) {
+	for _, want := range []Mode{NgramMode, TokenMode} {
+		got, err := ParseMode(want.String())
+		if err != nil || got != want {
+			t.Errorf("ParseMode(%q) = %v, %v, want %v", want.String(), got, err, want)
+		}
+	}
+	if _, err := ParseMode("sentence"); err == nil {
+		t.Errorf("ParseMode() error = nil, want error")
+	}
+}
@@ -0,0 +1,121 @@
+package chain
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"unicode"
+
+	"golang.org/x/text/cases"
+	"golang.org/x/text/runes"
+	"golang.org/x/text/transform"
+	"golang.org/x/text/unicode/norm"
+)
+
+// Normalization is the Unicode normalization form text is converted to before
+// it is split into tokens, so that the different encodings of the same
+// character are counted as the same token.
+type Normalization int
+
+const (
+	// NormalizeNone leaves text as it is.
+	NormalizeNone Normalization = iota
+	// NormalizeNFC composes characters and their accents, e.g. "e" followed by
+	// a combining acute accent becomes "é".
+	NormalizeNFC
+	// NormalizeNFKC is like NormalizeNFC, and also replaces compatibility
+	// characters with their plain equivalents, e.g. "ﬁ" becomes "fi" and "²"
+	// becomes "2".
+	NormalizeNFKC
+)
+
+var normalizationNames = []string{"none", "nfc", "nfkc"}
+
+func (n Normalization) String() string {
+	if n < 0 || int(n) >= len(normalizationNames) {
+		return fmt.Sprintf("Normalization(%d)", int(n))
+	}
+	return normalizationNames[n]
+}
+
+// ParseNormalization returns the Normalization named name: "none", "nfc" or
+// "nfkc"
+func ParseNormalization(name string) (Normalization, error) {
+	for n, normalizationName := range normalizationNames {
+		if strings.EqualFold(name, normalizationName) {
+			return Normalization(n), nil
+		}
+	}
+	return NormalizeNone, fmt.Errorf("unknown normalization %q, must be one of %s", name, strings.Join(normalizationNames, ", "))
+}
+
+// normalizes reports whether opts change text before it is tokenized
+func (opts Options) normalizes() bool {
+	return opts.Normalization != NormalizeNone || opts.CaseFold || opts.StripAccents || opts.StripControl
+}
+
+// normalizer returns the transformer that preprocesses text as chosen by opts,
+// or nil if text is used as it is. Transformers keep state, so each call
+// returns a new one.
+func (opts Options) normalizer() transform.Transformer {
+	if !opts.normalizes() {
+		return nil
+	}
+	var steps []transform.Transformer
+	if opts.StripControl {
+		steps = append(steps, runes.Remove(runes.Predicate(isControl)))
+	}
+	if opts.StripAccents {
+		// Accents are only separate from their letters once decomposed
+		decompose := norm.NFD
+		if opts.Normalization == NormalizeNFKC {
+			decompose = norm.NFKD
+		}
+		steps = append(steps, decompose, runes.Remove(runes.In(unicode.Mn)))
+	}
+	if opts.CaseFold {
+		steps = append(steps, cases.Fold())
+	}
+	// Folding and stripping may leave text decomposed, so it is always
+	// recomposed last
+	switch opts.Normalization {
+	case NormalizeNFKC:
+		steps = append(steps, norm.NFKC)
+	case NormalizeNFC:
+		steps = append(steps, norm.NFC)
+	default:
+		if opts.StripAccents {
+			steps = append(steps, norm.NFC)
+		}
+	}
+	return transform.Chain(steps...)
+}
+
+// Normalize preprocesses text the way text is preprocessed by a Model trained
+// with opts before it is split into tokens.
+func (opts Options) Normalize(text string) string {
+	t := opts.normalizer()
+	if t == nil {
+		return text
+	}
+	normalized, _, err := transform.String(t, text)
+	if err != nil {
+		return text
+	}
+	return normalized
+}
+
+// normalizeReader returns a reader of r preprocessed as chosen by opts
+func (opts Options) normalizeReader(r io.Reader) io.Reader {
+	t := opts.normalizer()
+	if t == nil {
+		return r
+	}
+	return transform.NewReader(r, t)
+}
+
+// isControl reports whether r is a control character other than whitespace,
+// e.g. a NUL or an escape
+func isControl(r rune) bool {
+	return unicode.IsControl(r) && !unicode.IsSpace(r)
+}
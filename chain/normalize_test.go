@@ -0,0 +1,68 @@
+package chain
+
+import (
+	"io/ioutil"
+	"reflect"
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+func TestNormalize(t *testing.T) {
+	tests := []struct {
+		name string
+		opts Options
+		text string
+		want string
+	}{
+		{"None", Options{}, "Café ﬁ\x00", "Café ﬁ\x00"},
+		{"NFC", Options{Normalization: NormalizeNFC}, "Café ﬁ", "Café ﬁ"},
+		{"NFKC", Options{Normalization: NormalizeNFKC}, "Café ﬁ x²", "Café fi x2"},
+		{"Case fold", Options{CaseFold: true}, "Straße STRASSE ΣΊΣΥΦΟΣ", "strasse strasse σίσυφοσ"},
+		{"Case fold NFC", Options{Normalization: NormalizeNFC, CaseFold: true}, "ÉCOLE", "école"},
+		{"Strip accents", Options{StripAccents: true}, "Crème brûlée, Café", "Creme brulee, Cafe"},
+		{"Strip accents NFKC", Options{Normalization: NormalizeNFKC, StripAccents: true}, "ﬁancé", "fiance"},
+		{"Strip control", Options{StripControl: true}, "a\x00b\x1b[0m\tc\r\nd\u0085", "ab[0m\tc\r\nd\u0085"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.opts.Normalize(tt.text); got != tt.want {
+				t.Errorf("Normalize() = %q, want %q", got, tt.want)
+			}
+			// Readers may only return part of a character at a time
+			b, err := ioutil.ReadAll(tt.opts.normalizeReader(iotest.OneByteReader(strings.NewReader(tt.text))))
+			if err != nil || string(b) != tt.want {
+				t.Errorf("normalizeReader() = %q, %v, want %q", b, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseNormalization(t *testing.T) {
+	for _, n := range []Normalization{NormalizeNone, NormalizeNFC, NormalizeNFKC} {
+		if got, err := ParseNormalization(n.String()); got != n || err != nil {
+			t.Errorf("ParseNormalization(%q) = %v, %v, want %v", n.String(), got, err, n)
+		}
+	}
+	if _, err := ParseNormalization("nfd"); err == nil {
+		t.Errorf("ParseNormalization(%q) error = nil, want error", "nfd")
+	}
+}
+
+func TestModelNormalize(t *testing.T) {
+	model := New(Options{N: 1, Mode: TokenMode, Words: true, Normalization: NormalizeNFC, CaseFold: true, StripAccents: true})
+	if err := model.Train(strings.NewReader("Café OLÉ café ole")); err != nil {
+		t.Fatalf("Train() error = %v", err)
+	}
+	want := StringHistogram{"cafe": {"ole": 2}, "ole": {"cafe": 1}}
+	if !reflect.DeepEqual(model.Histogram(), want) {
+		t.Errorf("Histogram() = %v, want %v", model.Histogram(), want)
+	}
+	// Prompts are normalized like the corpus
+	if got := model.Tokens("CAFÉ"); len(got) != 1 || got[0] != "cafe" {
+		t.Errorf("Tokens() = %q, want %q", got, []string{"cafe"})
+	}
+	if got := model.Generate("CAFÉ", 1); got != "cafe ole" {
+		t.Errorf("Generate() = %q, want %q", got, "cafe ole")
+	}
+}
@@ -0,0 +1,231 @@
+package chain
+
+import (
+	"container/list"
+	"fmt"
+	"math"
+	"math/rand"
+	"sort"
+	"sync"
+)
+
+// A Chooser selects n-grams at random, weighted by their frequency. It is
+// presorted for binary search so repeated selections from the same set are fast.
+type Chooser struct {
+	items  []string
+	totals []uint64
+	max    uint64
+}
+
+// NewChooser returns a Chooser over the n-grams in frequencies. Items are sorted
+// so that a Chooser built from the same frequencies always picks the same n-gram
+// for the same random source.
+func NewChooser(frequencies map[string]uint32) *Chooser {
+	items := make([]string, 0, len(frequencies))
+	for item := range frequencies {
+		items = append(items, item)
+	}
+	sort.Strings(items)
+	totals := make([]uint64, len(items))
+	var runningTotal uint64
+	for i, item := range items {
+		runningTotal += uint64(frequencies[item])
+		totals[i] = runningTotal
+	}
+	return &Chooser{items: items, totals: totals, max: runningTotal}
+}
+
+// Pick uses rng to return a single weighted random n-gram from the Chooser.
+func (c *Chooser) Pick(rng *rand.Rand) string {
+	if c.max == 0 {
+		return ""
+	}
+	return c.items[c.pick(rng)]
+}
+
+// pick uses rng to return the index of a weighted random n-gram. The Chooser
+// must not be empty.
+func (c *Chooser) pick(rng *rand.Rand) int {
+	r := uint64(rng.Int63n(int64(c.max))) + 1
+	return sort.Search(len(c.totals), func(i int) bool { return c.totals[i] >= r })
+}
+
+// DefaultChooserCacheSize is the number of Choosers a Model keeps for reuse if
+// its ChooserCacheSize is 0.
+const DefaultChooserCacheSize = 1 << 16
+
+// chooserKey identifies the Chooser of a context of k tokens joined into gram
+type chooserKey struct {
+	k    int
+	gram string
+}
+
+type chooserEntry struct {
+	key     chooserKey
+	chooser *Chooser
+}
+
+// chooserCache keeps the most recently used Choosers, so that Choosers are
+// only built for the contexts that are sampled from, and the contexts that
+// generation keeps coming back to are only sorted once. It is safe for
+// concurrent use.
+type chooserCache struct {
+	mu    sync.Mutex
+	items map[chooserKey]*list.Element
+	// recent orders the entries from most to least recently used
+	recent *list.List
+}
+
+func newChooserCache() *chooserCache {
+	return &chooserCache{items: make(map[chooserKey]*list.Element), recent: list.New()}
+}
+
+// get returns the Chooser cached for key. ok is false if there is none, and
+// true with a nil Chooser if key has no next n-grams.
+func (c *chooserCache) get(key chooserKey) (chooser *Chooser, ok bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.items[key]
+	if !ok {
+		return nil, false
+	}
+	c.recent.MoveToFront(e)
+	return e.Value.(*chooserEntry).chooser, true
+}
+
+// add caches chooser for key, evicting the least recently used Choosers to
+// keep at most size
+func (c *chooserCache) add(key chooserKey, chooser *Chooser, size int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if e, ok := c.items[key]; ok {
+		c.recent.MoveToFront(e)
+		return
+	}
+	c.items[key] = c.recent.PushFront(&chooserEntry{key, chooser})
+	for c.recent.Len() > size {
+		oldest := c.recent.Back()
+		c.recent.Remove(oldest)
+		delete(c.items, oldest.Value.(*chooserEntry).key)
+	}
+}
+
+// Sampling reshapes the distribution of next n-grams before each one is picked,
+// trading creativity against coherence without retraining. The zero value picks
+// n-grams in proportion to their frequency.
+type Sampling struct {
+	// Temperature divides the log-frequency of each n-gram. Values below 1 favour
+	// frequent n-grams and values above 1 flatten the distribution. 0 is treated
+	// as 1.
+	Temperature float64
+	// TopK only picks from the TopK most frequent n-grams. 0 means no limit.
+	TopK int
+	// TopP only picks from the smallest set of most frequent n-grams whose
+	// probabilities add up to at least TopP, i.e. nucleus sampling. 0 is
+	// treated as 1, which means no limit.
+	TopP float64
+	// Greedy always picks the most frequent n-gram, ignoring the other fields.
+	Greedy bool
+}
+
+// Validate returns an error if any of the fields of s are out of range.
+func (s Sampling) Validate() error {
+	if s.Temperature < 0 || math.IsNaN(s.Temperature) || math.IsInf(s.Temperature, 0) {
+		return fmt.Errorf("temperature must not be negative, got %v", s.Temperature)
+	}
+	if s.TopK < 0 {
+		return fmt.Errorf("top-k must not be negative, got %d", s.TopK)
+	}
+	if s.TopP < 0 || s.TopP > 1 || math.IsNaN(s.TopP) {
+		return fmt.Errorf("top-p must be between 0 and 1, got %v", s.TopP)
+	}
+	return nil
+}
+
+func (s Sampling) isProportional() bool {
+	return !s.Greedy && (s.Temperature == 0 || s.Temperature == 1) && s.TopK == 0 && (s.TopP == 0 || s.TopP == 1)
+}
+
+// PickWith uses rng to return a single random n-gram from the Chooser, drawn
+// from its distribution reshaped by s. Ties between equally frequent n-grams are
+// broken in sorted order, so the same rng state always picks the same n-gram.
+func (c *Chooser) PickWith(rng *rand.Rand, s Sampling) string {
+	if s.isProportional() || len(c.items) == 0 {
+		return c.Pick(rng)
+	}
+
+	weights := make([]float64, len(c.items))
+	for i := range c.items {
+		weights[i] = float64(c.frequency(i))
+	}
+	return pickWeighted(rng, c.items, weights, s)
+}
+
+// pickWeighted uses rng to return one of items, drawn in proportion to weights
+// reshaped by s. Items must be sorted, and ties between equal weights are broken
+// in that order.
+func pickWeighted(rng *rand.Rand, items []string, weights []float64, s Sampling) string {
+	// Order the n-grams from most to least likely
+	order := make([]int, 0, len(items))
+	for i := range items {
+		if weights[i] > 0 {
+			order = append(order, i)
+		}
+	}
+	if len(order) == 0 {
+		return ""
+	}
+	sort.Slice(order, func(i, j int) bool {
+		if weights[order[i]] != weights[order[j]] {
+			return weights[order[i]] > weights[order[j]]
+		}
+		return order[i] < order[j]
+	})
+	if s.Greedy {
+		return items[order[0]]
+	}
+	if s.TopK > 0 && s.TopK < len(order) {
+		order = order[:s.TopK]
+	}
+
+	temperature := s.Temperature
+	if temperature == 0 {
+		temperature = 1
+	}
+	// Scale relative to the most likely n-gram so that low temperatures can't overflow
+	maxLog := math.Log(weights[order[0]])
+	reshaped := make([]float64, len(order))
+	var total float64
+	for i, item := range order {
+		reshaped[i] = math.Exp((math.Log(weights[item]) - maxLog) / temperature)
+		total += reshaped[i]
+	}
+
+	if s.TopP > 0 && s.TopP < 1 {
+		var cumulative float64
+		for i, weight := range reshaped {
+			cumulative += weight
+			if cumulative >= s.TopP*total {
+				order, reshaped, total = order[:i+1], reshaped[:i+1], cumulative
+				break
+			}
+		}
+	}
+
+	r := rng.Float64() * total
+	for i, weight := range reshaped {
+		r -= weight
+		if r < 0 {
+			return items[order[i]]
+		}
+	}
+	return items[order[len(order)-1]]
+}
+
+// frequency returns the frequency of the ith n-gram
+func (c *Chooser) frequency(i int) uint64 {
+	if i == 0 {
+		return c.totals[0]
+	}
+	return c.totals[i] - c.totals[i-1]
+}
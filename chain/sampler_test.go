@@ -0,0 +1,251 @@
+package chain
+
+import (
+	"math/rand"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestChooserPick(t *testing.T) {
+	tests := []struct {
+		name        string
+		frequencies map[string]uint32
+		want        map[string]bool
+	}{
+		{"Single choice", map[string]uint32{"a": 3}, map[string]bool{"a": true}},
+		{"Zero weights are never picked", map[string]uint32{"a": 0, "b": 1, "c": 0}, map[string]bool{"b": true}},
+		{"Every weighted choice is picked", map[string]uint32{"a": 1, "b": 2, "c": 3}, map[string]bool{"a": true, "b": true, "c": true}},
+		{"Empty", map[string]uint32{}, map[string]bool{"": true}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			chooser := NewChooser(tt.frequencies)
+			rng := rand.New(rand.NewSource(1))
+			got := make(map[string]bool)
+			for i := 0; i < 1000; i++ {
+				got[chooser.Pick(rng)] = true
+			}
+			if len(got) != len(tt.want) {
+				t.Errorf("Pick() picked %v, want %v", got, tt.want)
+			}
+			for item := range got {
+				if !tt.want[item] {
+					t.Errorf("Pick() = %q, want one of %v", item, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestChooserPickWith(t *testing.T) {
+	frequencies := map[string]uint32{"a": 1, "b": 6, "c": 3, "d": 0}
+	tests := []struct {
+		name     string
+		sampling Sampling
+		want     map[string]bool
+	}{
+		{"Proportional", Sampling{}, map[string]bool{"a": true, "b": true, "c": true}},
+		{"Greedy", Sampling{Greedy: true, TopK: 3}, map[string]bool{"b": true}},
+		{"Top-k", Sampling{TopK: 2}, map[string]bool{"b": true, "c": true}},
+		{"Top-k larger than choices", Sampling{TopK: 10}, map[string]bool{"a": true, "b": true, "c": true}},
+		{"Top-p", Sampling{TopP: 0.5}, map[string]bool{"b": true}},
+		{"Top-p boundary", Sampling{TopP: 0.9}, map[string]bool{"b": true, "c": true}},
+		{"Top-k and top-p", Sampling{TopK: 1, TopP: 0.99}, map[string]bool{"b": true}},
+		{"High temperature", Sampling{Temperature: 5}, map[string]bool{"a": true, "b": true, "c": true}},
+		{"Low temperature", Sampling{Temperature: 0.01}, map[string]bool{"b": true}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			chooser := NewChooser(frequencies)
+			rng := rand.New(rand.NewSource(1))
+			got := make(map[string]bool)
+			for i := 0; i < 1000; i++ {
+				got[chooser.PickWith(rng, tt.sampling)] = true
+			}
+			if len(got) != len(tt.want) {
+				t.Errorf("PickWith() picked %v, want %v", got, tt.want)
+			}
+			for item := range got {
+				if !tt.want[item] {
+					t.Errorf("PickWith() = %q, want one of %v", item, tt.want)
+				}
+			}
+		})
+	}
+
+	// Greedy ties are broken in sorted order
+	tied := NewChooser(map[string]uint32{"z": 2, "y": 2, "x": 1})
+	if got := tied.PickWith(rand.New(rand.NewSource(1)), Sampling{Greedy: true}); got != "y" {
+		t.Errorf("PickWith() = %q, want %q", got, "y")
+	}
+}
+
+func TestChooserPickWithTemperature(t *testing.T) {
+	chooser := NewChooser(map[string]uint32{"a": 1, "b": 9})
+	share := func(sampling Sampling) float64 {
+		rng := rand.New(rand.NewSource(1))
+		picked := 0
+		for i := 0; i < 10000; i++ {
+			if chooser.PickWith(rng, sampling) == "a" {
+				picked++
+			}
+		}
+		return float64(picked) / 10000
+	}
+	cold, normal, hot := share(Sampling{Temperature: 0.5}), share(Sampling{Temperature: 1}), share(Sampling{Temperature: 2})
+	if !(cold < normal && normal < hot) {
+		t.Errorf("PickWith() picked the rare n-gram %v, %v and %v of the time, want increasing with temperature", cold, normal, hot)
+	}
+	// The probability of "a" at temperature 2 is sqrt(1) / (sqrt(1) + sqrt(9)) = 0.25
+	if hot < 0.23 || hot > 0.27 {
+		t.Errorf("PickWith() picked the rare n-gram %v of the time at temperature 2, want 0.25", hot)
+	}
+}
+
+func TestSamplingValidate(t *testing.T) {
+	tests := []struct {
+		name     string
+		sampling Sampling
+		wantErr  bool
+	}{
+		{"Zero", Sampling{}, false},
+		{"Valid", Sampling{Temperature: 0.7, TopK: 40, TopP: 0.95}, false},
+		{"Negative temperature", Sampling{Temperature: -1}, true},
+		{"Negative top-k", Sampling{TopK: -1}, true},
+		{"Top-p above 1", Sampling{TopP: 1.5}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.sampling.Validate(); (err != nil) != tt.wantErr {
+				t.Errorf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestChooserCache(t *testing.T) {
+	cache := newChooserCache()
+	a, b, c := chooserKey{1, "a"}, chooserKey{1, "b"}, chooserKey{2, "a"}
+	cache.add(a, NewChooser(map[string]uint32{"x": 1}), 2)
+	cache.add(b, nil, 2)
+	if _, ok := cache.get(a); !ok {
+		t.Fatalf("get(%v) ok = false, want true", a)
+	}
+	// b is now the least recently used
+	cache.add(c, NewChooser(map[string]uint32{"y": 1}), 2)
+	if _, ok := cache.get(b); ok {
+		t.Errorf("get(%v) ok = true after it was evicted, want false", b)
+	}
+	for _, key := range []chooserKey{a, c} {
+		if chooser, ok := cache.get(key); !ok || chooser == nil {
+			t.Errorf("get(%v) = %v, %v, want a cached Chooser", key, chooser, ok)
+		}
+	}
+}
+
+func TestModelChooserCacheSize(t *testing.T) {
+	text := "the cat sat on the mat and the dog sat on the log and the cat ate the rat"
+	generate := func(size int) []string {
+		model := New(Options{N: 1, Mode: TokenMode, Words: true})
+		model.ChooserCacheSize = size
+		if err := model.Train(strings.NewReader(text)); err != nil {
+			t.Fatalf("Train() error = %v", err)
+		}
+		// Generators share the model's cache
+		texts := make([]string, 8)
+		var wg sync.WaitGroup
+		for i := range texts {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				texts[i] = NewGenerator(model, int64(i)).Generate("the", 50)
+			}(i)
+		}
+		wg.Wait()
+		return texts
+	}
+	want := generate(0)
+	got := generate(2)
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("Generate() with a cache of 2 = %q, want %q", got[i], want[i])
+		}
+	}
+}
+
+var (
+	benchmarkOnce  sync.Once
+	benchmarkModel *Model
+)
+
+// newBenchmarkModel returns a word model trained on a large corpus of random
+// words, whose frequencies follow Zipf's law like those of natural language
+func newBenchmarkModel(b *testing.B) *Model {
+	benchmarkOnce.Do(func() {
+		rng := rand.New(rand.NewSource(1))
+		zipf := rand.NewZipf(rng, 1.1, 1, 20000)
+		var text strings.Builder
+		for i := 0; i < 1000000; i++ {
+			text.WriteString("w")
+			text.WriteString(strings.Repeat("o", int(zipf.Uint64()%7)))
+			text.WriteString(string(rune('a' + zipf.Uint64()%26)))
+			text.WriteString(" ")
+		}
+		benchmarkModel = New(Options{N: 2, Mode: TokenMode, Words: true})
+		if err := benchmarkModel.Train(strings.NewReader(text.String())); err != nil {
+			b.Fatal(err)
+		}
+	})
+	return benchmarkModel
+}
+
+// choosersFromStringHistogram builds a Chooser over the next n-grams of every
+// n-gram in hist that has any, as models did before sampling their first token
+func choosersFromStringHistogram(hist StringHistogram) map[string]*Chooser {
+	choosers := make(map[string]*Chooser)
+	for gram := range hist {
+		if len(hist[gram]) > 0 {
+			choosers[gram] = NewChooser(hist[gram])
+		}
+	}
+	return choosers
+}
+
+// BenchmarkStartupEager measures sampling a first token after building a
+// Chooser for every context up front
+func BenchmarkStartupEager(b *testing.B) {
+	hist := newBenchmarkModel(b).Histogram()
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for _, chooser := range choosersFromStringHistogram(hist) {
+			chooser.Pick(rand.New(rand.NewSource(1)))
+			break
+		}
+	}
+}
+
+// BenchmarkStartupLazy measures generating the first 1000 tokens from a model
+// whose Choosers are built as they are needed
+func BenchmarkStartupLazy(b *testing.B) {
+	model := newBenchmarkModel(b)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		model.choosers = newChooserCache()
+		NewGenerator(model, 1).Generate("", 1000)
+	}
+}
+
+// BenchmarkGenerate measures generating 1000 tokens once the Choosers they
+// need are cached
+func BenchmarkGenerate(b *testing.B) {
+	model := newBenchmarkModel(b)
+	NewGenerator(model, 1).Generate("", 1000)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		NewGenerator(model, 1).Generate("", 1000)
+	}
+}
@@ -0,0 +1,233 @@
+package chain
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+	"unicode/utf8"
+)
+
+// Segment is the unit of text a Model learns to start and end. Each segment is
+// counted separately, padded with BOS markers at the start and EOS markers at
+// the end, so the model learns how segments begin and where they stop instead
+// of running one into the next.
+type Segment int
+
+const (
+	// SegmentNone reads each document as one continuous stream of tokens,
+	// without BOS or EOS markers.
+	SegmentNone Segment = iota
+	// SegmentDocuments pads each document, e.g. each file or tar entry.
+	SegmentDocuments
+	// SegmentLines pads each non-empty line.
+	SegmentLines
+	// SegmentSentences pads each sentence. A sentence ends after a ".", "!",
+	// "?" or "…", and any closing quotes or brackets, that is followed by
+	// whitespace or the end of the document.
+	SegmentSentences
+)
+
+var segmentNames = []string{"none", "documents", "lines", "sentences"}
+
+func (s Segment) String() string {
+	if s < 0 || int(s) >= len(segmentNames) {
+		return fmt.Sprintf("Segment(%d)", int(s))
+	}
+	return segmentNames[s]
+}
+
+// ParseSegment returns the Segment named name: "none", "documents", "lines" or
+// "sentences"
+func ParseSegment(name string) (Segment, error) {
+	for s, segmentName := range segmentNames {
+		if name == segmentName {
+			return Segment(s), nil
+		}
+	}
+	return SegmentNone, fmt.Errorf("unknown segment %q, must be one of %s", name, strings.Join(segmentNames, ", "))
+}
+
+// separator returns the text written between two generated segments
+func (s Segment) separator() string {
+	switch s {
+	case SegmentLines:
+		return "\n"
+	case SegmentDocuments:
+		return "\n\n"
+	}
+	return " "
+}
+
+const (
+	// BOS is the token that pads the start of every segment. It is stripped
+	// from training text.
+	BOS = "\x02"
+	// EOS is the token that pads the end of every segment. It is stripped from
+	// training text.
+	EOS = "\x03"
+)
+
+// stripMarkers removes BOS and EOS from text
+var stripMarkers = strings.NewReplacer(BOS, "", EOS, "")
+
+// newTokenScanner returns a Scanner over the tokens of r split by tokenizer
+func newTokenScanner(r io.Reader, tokenizer Tokenizer) *bufio.Scanner {
+	scanner := bufio.NewScanner(r)
+	scanner.Split(tokenizer.Split)
+	return scanner
+}
+
+// cleanToken lowercases token if opts.Lowercase is set and, for segmented
+// models, strips BOS and EOS from it
+func cleanToken(token string, opts Options) string {
+	if opts.Segment != SegmentNone {
+		token = stripMarkers.Replace(token)
+	}
+	// Lowercasing would replace the bytes of invalid UTF-8 that the bytes
+	// Tokenizer returns
+	if opts.Lowercase && utf8.ValidString(token) {
+		token = strings.ToLower(token)
+	}
+	return token
+}
+
+// tokenize splits text into tokens with tokenizer and cleans them according to opts
+func tokenize(text string, opts Options, tokenizer Tokenizer) []string {
+	scanner := newTokenScanner(strings.NewReader(text), tokenizer)
+	var tokens []string
+	for scanner.Scan() {
+		if token := cleanToken(scanner.Text(), opts); token != "" {
+			tokens = append(tokens, token)
+		}
+	}
+	return tokens
+}
+
+// scanSegments calls fn with the tokens of each segment of r, as chosen by
+// opts.Segment and split by tokenizer. Segments without tokens are skipped.
+func scanSegments(r io.Reader, opts Options, tokenizer Tokenizer, fn func(tokens []string)) error {
+	if opts.Segment == SegmentLines {
+		br := bufio.NewReader(r)
+		for {
+			line, err := br.ReadString('\n')
+			if tokens := tokenize(strings.TrimRight(line, "\r\n"), opts, tokenizer); len(tokens) > 0 {
+				fn(tokens)
+			}
+			if err == io.EOF {
+				return nil
+			} else if err != nil {
+				return err
+			}
+		}
+	}
+
+	sentences := opts.Segment == SegmentSentences
+	// Tokenizers that don't separate their tokens split text into characters
+	characters := tokenizer.Separator() == ""
+	scanner := newTokenScanner(r, tokenizer)
+	var tokens []string
+	// ending is set once a character-level sentence has ended, but whitespace
+	// hasn't confirmed it yet
+	ending := false
+	for scanner.Scan() {
+		token := cleanToken(scanner.Text(), opts)
+		if token == "" {
+			continue
+		}
+		isSpace := strings.TrimSpace(token) == ""
+		if sentences && len(tokens) == 0 && isSpace {
+			// Drop the whitespace between sentences
+			continue
+		}
+		if sentences && characters {
+			switch {
+			case ending && isSpace:
+				fn(tokens)
+				tokens, ending = nil, false
+				continue
+			case ending && strings.ContainsAny(token, sentenceClosers):
+				tokens = append(tokens, token)
+				continue
+			}
+			ending = strings.ContainsAny(token, sentenceEnders)
+		}
+		tokens = append(tokens, token)
+		if sentences && !characters && endsSentence(token) {
+			fn(tokens)
+			tokens = nil
+		}
+	}
+	if len(tokens) > 0 {
+		fn(tokens)
+	}
+	return scanner.Err()
+}
+
+const (
+	sentenceEnders  = ".!?…"
+	sentenceClosers = "\"')]}’”»"
+)
+
+// endsSentence reports whether word ends a sentence
+func endsSentence(word string) bool {
+	word = strings.TrimRight(word, sentenceClosers)
+	r, _ := utf8.DecodeLastRuneInString(word)
+	return strings.ContainsRune(sentenceEnders, r)
+}
+
+// pad returns tokens preceded by N BOS markers and followed by EOS markers, one
+// in TokenMode and N in NgramMode so that every segment ends with a whole next
+// n-gram
+func (opts Options) pad(tokens []string) []string {
+	eos := opts.N
+	if opts.Mode == TokenMode {
+		eos = 1
+	}
+	padded := make([]string, 0, opts.N+len(tokens)+eos)
+	for i := 0; i < opts.N; i++ {
+		padded = append(padded, BOS)
+	}
+	padded = append(padded, tokens...)
+	for i := 0; i < eos; i++ {
+		padded = append(padded, EOS)
+	}
+	return padded
+}
+
+// window returns the number of tokens in each transition of a segmented model,
+// and the number of tokens the next transition starts after when generating
+// or evaluating
+func (opts Options) window() (window, step int) {
+	if opts.Mode == TokenMode {
+		return opts.N + 1, 1
+	}
+	return opts.N * 2, opts.N
+}
+
+// start returns the context that every segment of m starts from
+func (m *Model) start() []string {
+	tokens := make([]string, m.Options.N)
+	for i := range tokens {
+		tokens[i] = BOS
+	}
+	return tokens
+}
+
+// withoutMarkers returns tokens without the BOS markers they start with
+func withoutMarkers(tokens []string) []string {
+	for len(tokens) > 0 && tokens[0] == BOS {
+		tokens = tokens[1:]
+	}
+	return tokens
+}
+
+// indexOf returns the index of the first token in tokens equal to token, or -1
+func indexOf(tokens []string, token string) int {
+	for i, t := range tokens {
+		if t == token {
+			return i
+		}
+	}
+	return -1
+}
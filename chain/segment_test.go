@@ -0,0 +1,112 @@
+package chain
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestScanSegments(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		opts Options
+		want [][]string
+	}{
+		{"Documents", "ab\ncd", Options{Segment: SegmentDocuments}, [][]string{{"a", "b", "\n", "c", "d"}}},
+		{"Lines", "ab\r\n\ncd\n", Options{Segment: SegmentLines}, [][]string{{"a", "b"}, {"c", "d"}}},
+		{"Word lines", "one two\nthree\n", Options{Words: true, Segment: SegmentLines}, [][]string{{"one", "two"}, {"three"}}},
+		{"Word sentences", `One two. "Three?" Four`, Options{Words: true, Segment: SegmentSentences},
+			[][]string{{"One", "two."}, {`"Three?"`}, {"Four"}}},
+		{"Character sentences", "A.b. C!)  D", Options{Segment: SegmentSentences},
+			[][]string{{"A", ".", "b", "."}, {"C", "!", ")"}, {"D"}}},
+		{"Whitespace sentences", "One.\n\nTwo  three. ", Options{Tokenizer: "whitespace", Segment: SegmentSentences},
+			[][]string{{"One", "."}, {"Two", "  ", "three", "."}}},
+		{"Markers are stripped", "a\x02b\x03", Options{Segment: SegmentDocuments, Lowercase: true}, [][]string{{"a", "b"}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tokenizer, _, err := tt.opts.tokenizers()
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got [][]string
+			err = scanSegments(strings.NewReader(tt.text), tt.opts, tokenizer, func(tokens []string) {
+				got = append(got, tokens)
+			})
+			if err != nil {
+				t.Fatalf("scanSegments() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("scanSegments() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestModelTrainSegments(t *testing.T) {
+	tests := []struct {
+		name string
+		opts Options
+		want StringHistogram
+	}{
+		{"Token mode", Options{N: 1, Mode: TokenMode, Segment: SegmentLines}, StringHistogram{
+			BOS: {"a": 1, "c": 1},
+			"a": {"b": 1},
+			"b": {EOS: 1},
+			"c": {EOS: 1},
+		}},
+		{"Ngram mode", Options{N: 2, Segment: SegmentLines}, StringHistogram{
+			BOS + BOS: {"ab": 1, "c" + EOS: 1},
+			BOS + "a": {"b" + EOS: 1},
+			BOS + "c": {EOS + EOS: 1},
+			"ab":      {EOS + EOS: 1},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			model := New(tt.opts)
+			if err := model.Train(strings.NewReader("ab\nc\n")); err != nil {
+				t.Fatalf("Train() error = %v", err)
+			}
+			if !reflect.DeepEqual(model.Histogram(), tt.want) {
+				t.Errorf("Histogram() = %q, want %q", model.Histogram(), tt.want)
+			}
+		})
+	}
+}
+
+func TestGeneratorSegments(t *testing.T) {
+	model := New(Options{N: 1, Mode: TokenMode, Words: true, Segment: SegmentSentences})
+	if err := model.Train(strings.NewReader("The cat sat. The dog ran. A bird flew.")); err != nil {
+		t.Fatalf("Train() error = %v", err)
+	}
+	tests := []struct {
+		name     string
+		segments int
+		max      int
+		want     int
+	}{
+		{"One sentence", 0, 100, 1},
+		{"Several sentences", 3, 100, 3},
+		{"Max", 3, 2, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for seed := int64(0); seed < 10; seed++ {
+				generator := NewGenerator(model, seed)
+				generator.Segments = tt.segments
+				got := generator.Generate("", tt.max)
+				if strings.ContainsAny(got, BOS+EOS) {
+					t.Fatalf("Generate() = %q, want no markers", got)
+				}
+				if sentences := strings.Count(got, "."); sentences != tt.want {
+					t.Errorf("Generate() = %q with %d sentences, want %d", got, sentences, tt.want)
+				}
+				if words := len(strings.Fields(got)); words > tt.max {
+					t.Errorf("Generate() = %q with %d words, want at most %d", got, words, tt.max)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,221 @@
+package chain
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"sync"
+	"unicode"
+	"unicode/utf8"
+)
+
+// shardSize is roughly how much text each goroutine counts at a time when a
+// Model trains with several Jobs. It is a variable so that tests can split
+// small texts.
+var shardSize = 1 << 20
+
+// shard is a piece of a document
+type shard struct {
+	index int
+	text  []byte
+	// first is set on the first shard of each document
+	first bool
+}
+
+// shardCounts holds the counts of the transitions in a shard, and the tokens
+// that the transitions spanning it and its neighbours start and end with
+type shardCounts struct {
+	shard
+	counts     *store
+	head, tail []string
+	err        error
+}
+
+// shardCounter counts documents split into shards on several goroutines, and
+// merges the counts of every shard into one store. Documents are split just
+// before a line break that follows a non-space character, which every
+// Tokenizer but regexes ends a token at and every segment of lines ends at, so
+// the shards have the same tokens as the whole document. The transitions that
+// span two shards are counted once both have been, from the tokens at their
+// edges, so the merged counts are the same as counting on one goroutine.
+// Documents segmented into sentences or read by a regex Tokenizer are counted
+// whole, but still in parallel with each other.
+type shardCounter struct {
+	opts      Options
+	tokenizer Tokenizer
+	counts    *store
+	// split is set if documents are split into shards, and spans if
+	// transitions may span them
+	split, spans bool
+	index        int
+	shards       chan shard
+	results      chan shardCounts
+	// failed is closed once counting fails with err
+	failed   chan struct{}
+	err      error
+	finished chan struct{}
+	once     sync.Once
+}
+
+// newShardCounter returns a shardCounter that adds the transitions of the
+// documents it counts to counts on jobs goroutines, and merges them on another
+func newShardCounter(opts Options, tokenizer Tokenizer, counts *store, jobs int) *shardCounter {
+	_, regex := tokenizer.(regexTokenizer)
+	c := &shardCounter{
+		opts:      opts,
+		tokenizer: tokenizer,
+		counts:    counts,
+		split:     !regex && (opts.Segment == SegmentNone || opts.Segment == SegmentLines),
+		spans:     opts.Segment == SegmentNone,
+		shards:    make(chan shard),
+		results:   make(chan shardCounts),
+		failed:    make(chan struct{}),
+		finished:  make(chan struct{}),
+	}
+	var workers sync.WaitGroup
+	for i := 0; i < jobs; i++ {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			for s := range c.shards {
+				c.results <- c.countShard(s)
+			}
+		}()
+	}
+	go func() {
+		workers.Wait()
+		close(c.results)
+	}()
+	go c.merge()
+	return c
+}
+
+// close waits for every shard sent to be counted and merged, and returns the
+// error counting failed with, if it has
+func (c *shardCounter) close() error {
+	c.once.Do(func() {
+		close(c.shards)
+		<-c.finished
+	})
+	return c.err
+}
+
+// count splits doc into shards and sends them to be counted. It returns the
+// error counting failed with, if it has.
+func (c *shardCounter) count(doc io.Reader) error {
+	if !c.split {
+		text, err := ioutil.ReadAll(doc)
+		if err != nil {
+			return err
+		}
+		return c.send(text, true)
+	}
+	first := true
+	text := make([]byte, 0, shardSize)
+	for {
+		if len(text) == cap(text) {
+			// Shards only end at line breaks, and grow until they reach one
+			grown := make([]byte, len(text), cap(text)*2)
+			copy(grown, text)
+			text = grown
+		}
+		n, err := io.ReadFull(doc, text[len(text):cap(text)])
+		text = text[:len(text)+n]
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			if len(text) == 0 {
+				return nil
+			}
+			return c.send(text, first)
+		} else if err != nil {
+			return err
+		}
+		if i := lastBoundary(text); i > 0 {
+			rest := make([]byte, len(text)-i, shardSize+len(text)-i)
+			copy(rest, text[i:])
+			if err := c.send(text[:i], first); err != nil {
+				return err
+			}
+			text, first = rest, false
+		}
+	}
+}
+
+// lastBoundary returns the index of the last line break in text that follows
+// a character other than whitespace, or -1 if there is none
+func lastBoundary(text []byte) int {
+	for i := bytes.LastIndexByte(text, '\n'); i > 0; i = bytes.LastIndexByte(text[:i], '\n') {
+		if r, _ := utf8.DecodeLastRune(text[:i]); !unicode.IsSpace(r) {
+			return i
+		}
+	}
+	return -1
+}
+
+func (c *shardCounter) send(text []byte, first bool) error {
+	select {
+	case c.shards <- shard{c.index, text, first}:
+		c.index++
+		return nil
+	case <-c.failed:
+		return c.err
+	}
+}
+
+// countShard counts the transitions in s
+func (c *shardCounter) countShard(s shard) shardCounts {
+	result := shardCounts{shard: shard{index: s.index, first: s.first}, counts: newStore(c.opts, 0)}
+	r := bytes.NewReader(s.text)
+	if c.spans {
+		result.head, result.tail, result.err = countStream(r, c.opts, c.tokenizer, result.counts.addWindow)
+	} else {
+		result.err = countTransitions(r, c.opts, c.tokenizer, result.counts.addWindow)
+	}
+	return result
+}
+
+// merge merges the counts of every shard as they are counted, then counts the
+// transitions that span each shard and the one before it, in order
+func (c *shardCounter) merge() {
+	defer close(c.finished)
+	window := streamWindow(c.opts)
+	pending := make(map[int]shardCounts)
+	next := 0
+	// edge holds the last window-1 tokens before the next shard
+	var edge []string
+	for result := range c.results {
+		if c.err != nil {
+			continue
+		}
+		err := result.err
+		if err == nil {
+			err = c.counts.merge(result.counts)
+			result.counts = nil
+		}
+		if err == nil && c.spans {
+			pending[result.index] = result
+			for err == nil {
+				p, ok := pending[next]
+				if !ok {
+					break
+				}
+				delete(pending, next)
+				next++
+				if p.first {
+					edge = nil
+				}
+				tokens := append(edge[:len(edge):len(edge)], p.head...)
+				for i := 0; i < len(edge) && i+window <= len(tokens) && err == nil; i++ {
+					err = c.counts.addWindow(tokens[i : i+window])
+				}
+				edge = append(edge[:len(edge):len(edge)], p.tail...)
+				if len(edge) > window-1 {
+					edge = edge[len(edge)-(window-1):]
+				}
+			}
+		}
+		if err != nil {
+			c.err = err
+			close(c.failed)
+		}
+	}
+}
@@ -0,0 +1,109 @@
+package chain
+
+import (
+	"errors"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestShardedTrain(t *testing.T) {
+	docs := []string{
+		"The quick brown fox jumps over the lazy dog.\nThe dog sleeps! Does the fox?\n\n  Indented line\r\nCRLF line\n" +
+			"Café café ́mark\n“Quoted,” she said... and left.\n\tTabbed\nend",
+		"",
+		"A second document\nwith a few lines\nof its own. It ends without a line break",
+		strings.Repeat("one two three four five six seven\n", 20),
+	}
+	tests := []struct {
+		name string
+		opts Options
+	}{
+		{"Characters", Options{N: 3}},
+		{"Words", Options{N: 2, Words: true}},
+		{"Token mode", Options{N: 3, Mode: TokenMode, Words: true, Backoff: true}},
+		{"Lowercase", Options{N: 2, Lowercase: true, Backoff: true}},
+		{"Graphemes", Options{N: 2, Mode: TokenMode, Tokenizer: "graphemes"}},
+		{"Punctuation", Options{N: 2, Tokenizer: "punctuation"}},
+		{"Whitespace", Options{N: 3, Mode: TokenMode, Tokenizer: "whitespace"}},
+		{"Bytes", Options{N: 4, Mode: TokenMode, Tokenizer: "bytes"}},
+		{"Regex", Options{N: 2, Tokenizer: `regex:\w+\s*`}},
+		{"BPE", Options{N: 2, Mode: TokenMode, Tokenizer: "bpe:30"}},
+		{"Normalization", Options{N: 2, Normalization: NormalizeNFC, StripAccents: true, CaseFold: true}},
+		{"Lines", Options{N: 2, Mode: TokenMode, Words: true, Segment: SegmentLines}},
+		{"Sentences", Options{N: 2, Words: true, Segment: SegmentSentences, Backoff: true}},
+		{"Documents", Options{N: 2, Mode: TokenMode, Segment: SegmentDocuments}},
+	}
+	defer func(size int) { shardSize = size }(shardSize)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			readers := func() (readers []io.Reader) {
+				for _, doc := range docs {
+					readers = append(readers, strings.NewReader(doc))
+				}
+				return readers
+			}
+			model := New(tt.opts)
+			if err := model.Train(readers()...); err != nil {
+				t.Fatalf("Train() error = %v", err)
+			}
+			var want []StringHistogram
+			for k := 1; k <= tt.opts.N; k++ {
+				want = append(want, model.histogram(k))
+			}
+
+			for _, size := range []int{1, 5, 16, 64, 1 << 20} {
+				for _, jobs := range []int{2, 4} {
+					shardSize = size
+					sharded := New(tt.opts)
+					sharded.Jobs = jobs
+					if err := sharded.Train(readers()...); err != nil {
+						t.Fatalf("Train() with %d jobs error = %v", jobs, err)
+					}
+					if sharded.Corpus != model.Corpus {
+						t.Errorf("Train() with %d jobs corpus = %+v, want %+v", jobs, sharded.Corpus, model.Corpus)
+					}
+					for k := 1; k <= tt.opts.N; k++ {
+						if got := sharded.histogram(k); !reflect.DeepEqual(got, want[k-1]) {
+							t.Errorf("Train() with %d jobs in shards of %d bytes histogram(%d) = %v, want %v", jobs, size, k, got, want[k-1])
+						}
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestShardedTrainMemoryLimit(t *testing.T) {
+	defer func(size int) { shardSize = size }(shardSize)
+	shardSize = 64
+	model := New(Options{N: 4, Backoff: true})
+	model.MemoryLimit = 1 << 10
+	model.Jobs = 4
+	err := model.Train(strings.NewReader(strings.Repeat("Every line of this text is counted in its own shard.\n", 100)))
+	if !errors.Is(err, ErrMemoryLimit) {
+		t.Errorf("Train() error = %v, want %v", err, ErrMemoryLimit)
+	}
+}
+
+func TestLastBoundary(t *testing.T) {
+	tests := []struct {
+		text string
+		want int
+	}{
+		{"", -1},
+		{"no line breaks", -1},
+		{"\nleading", -1},
+		{"one\ntwo", 3},
+		{"one\ntwo\nthree", 7},
+		{"one\ntwo \n", 3},
+		{"one\r\ntwo\n\n", 8},
+		{"one\n\n", 3},
+	}
+	for _, tt := range tests {
+		if got := lastBoundary([]byte(tt.text)); got != tt.want {
+			t.Errorf("lastBoundary(%q) = %d, want %d", tt.text, got, tt.want)
+		}
+	}
+}
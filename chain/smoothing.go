@@ -0,0 +1,435 @@
+package chain
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"sort"
+	"strings"
+)
+
+// Smoothing is a method of estimating the probability of a next n-gram from the
+// frequencies in a model's histograms.
+type Smoothing int
+
+const (
+	// MaximumLikelihood estimates the probability of a next n-gram as its share
+	// of the frequencies of the current n-gram. Transitions that were never seen
+	// have no probability.
+	MaximumLikelihood Smoothing = iota
+	// AddK adds Estimator.K to the frequency of every next n-gram in the
+	// vocabulary. With K = 1 this is Laplace smoothing.
+	AddK
+	// WittenBell interpolates with shorter contexts in proportion to the number
+	// of distinct next n-grams each context was seen with.
+	WittenBell
+	// AbsoluteDiscounting subtracts Estimator.Discount from every frequency and
+	// interpolates with shorter contexts using the mass that was taken away.
+	AbsoluteDiscounting
+	// KneserNey is interpolated Kneser-Ney smoothing. It discounts like
+	// AbsoluteDiscounting, but shorter contexts count the number of distinct
+	// contexts each next n-gram follows instead of how often it follows them.
+	KneserNey
+)
+
+var smoothingNames = []string{"mle", "add-k", "witten-bell", "absolute-discounting", "kneser-ney"}
+
+func (s Smoothing) String() string {
+	if s < 0 || int(s) >= len(smoothingNames) {
+		return fmt.Sprintf("Smoothing(%d)", int(s))
+	}
+	return smoothingNames[s]
+}
+
+// ParseSmoothing returns the Smoothing named name: "mle", "add-k" or "laplace",
+// "witten-bell", "absolute-discounting" or "kneser-ney"
+func ParseSmoothing(name string) (Smoothing, error) {
+	if name == "laplace" {
+		return AddK, nil
+	}
+	for s, smoothingName := range smoothingNames {
+		if name == smoothingName {
+			return Smoothing(s), nil
+		}
+	}
+	return MaximumLikelihood, fmt.Errorf("unknown smoothing %q, must be one of %s", name, strings.Join(smoothingNames, ", "))
+}
+
+// Estimator estimates the probability of next n-grams with a Smoothing method.
+// The zero value is the maximum likelihood estimate.
+//
+// Every method except MaximumLikelihood and AddK interpolates the current
+// context with shorter ones: the contexts of 1 to N-1 tokens if the model was
+// trained with Options.Backoff, then the frequency of each next n-gram
+// regardless of context, and finally the uniform distribution over the
+// vocabulary. Only next n-grams in the model's Vocabulary have a probability.
+type Estimator struct {
+	Smoothing Smoothing
+	// K is added to every frequency by AddK. 0 is treated as 1.
+	K float64
+	// Discount is subtracted from every frequency by AbsoluteDiscounting and
+	// KneserNey. 0 is treated as 0.75.
+	Discount float64
+}
+
+// Validate returns an error if any of the fields of e are out of range.
+func (e Estimator) Validate() error {
+	if e.Smoothing < MaximumLikelihood || e.Smoothing > KneserNey {
+		return fmt.Errorf("unknown smoothing %v", e.Smoothing)
+	}
+	if e.K < 0 || math.IsNaN(e.K) || math.IsInf(e.K, 0) {
+		return fmt.Errorf("k must not be negative, got %v", e.K)
+	}
+	if e.Discount < 0 || e.Discount > 1 || math.IsNaN(e.Discount) {
+		return fmt.Errorf("discount must be between 0 and 1, got %v", e.Discount)
+	}
+	return nil
+}
+
+func (e Estimator) k() float64 {
+	if e.K == 0 {
+		return 1
+	}
+	return e.K
+}
+
+func (e Estimator) discount() float64 {
+	if e.Discount == 0 {
+		return 0.75
+	}
+	return e.Discount
+}
+
+// smoothingTable holds the frequencies of the next n-grams of contexts of k
+// tokens. Order 0 has a single context, "".
+type smoothingTable struct {
+	k int
+	// counts holds the frequencies of the contexts of k tokens, if k isn't 0
+	counts counter
+	// unigram holds the frequencies of the single context of order 0
+	unigram *Chooser
+}
+
+// observed returns every next n-gram seen after the last k tokens of context,
+// their frequencies, and the total of the frequencies. The n-grams of order 0
+// are in sorted order.
+func (t *smoothingTable) observed(m *Model, context []string) (items []string, frequencies []float64, total float64) {
+	if t.k == 0 {
+		frequencies = make([]float64, len(t.unigram.items))
+		for i := range t.unigram.items {
+			frequencies[i] = float64(t.unigram.frequency(i))
+		}
+		return t.unigram.items, frequencies, float64(t.unigram.max)
+	}
+	t.counts.next(context[len(context)-t.k:], func(next []string, count uint32) {
+		items = append(items, m.join(next))
+		frequencies = append(frequencies, float64(count))
+		total += float64(count)
+	})
+	return items, frequencies, total
+}
+
+// stats returns the frequency of next after the last k tokens of context, and
+// the total frequency and number of distinct next n-grams of that context
+func (t *smoothingTable) stats(m *Model, context []string, next string) (frequency, total, types float64) {
+	if t.k == 0 {
+		if i := sort.SearchStrings(t.unigram.items, next); i < len(t.unigram.items) && t.unigram.items[i] == next {
+			frequency = float64(t.unigram.frequency(i))
+		}
+		return frequency, float64(t.unigram.max), float64(len(t.unigram.items))
+	}
+	t.counts.next(context[len(context)-t.k:], func(nextGram []string, count uint32) {
+		if m.join(nextGram) == next {
+			frequency = float64(count)
+		}
+		total += float64(count)
+		types++
+	})
+	return frequency, total, types
+}
+
+// smoothingTables holds the tables of every context length of a model, longest first
+type smoothingTables struct {
+	tables []*smoothingTable
+	vocab  []string
+}
+
+// smoothingTables returns the frequency tables used by e, building them on
+// first use. The tables read the model's counts in place, except for order 0
+// and the shorter contexts of Kneser-Ney, which count the contexts that each
+// next n-gram follows.
+func (m *Model) smoothingTables(e Estimator) *smoothingTables {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	kneserNey := e.Smoothing == KneserNey
+	if kneserNey && m.kneserNeyTables != nil {
+		return m.kneserNeyTables
+	} else if !kneserNey && m.tables != nil {
+		return m.tables
+	}
+
+	var orders []int
+	for k := m.Options.N; k >= 1; k-- {
+		if m.counts.counted(k) {
+			orders = append(orders, k)
+		}
+	}
+	n := m.Options.N
+	unigram := make(map[string]uint32)
+	m.counts.walk(n, func(path []string, count uint32) {
+		unigram[m.join(path[n:])] += count
+	})
+	vocab := NewChooser(unigram)
+
+	t := &smoothingTables{vocab: vocab.items}
+	t.tables = append(t.tables, &smoothingTable{k: n, counts: m.counts})
+	for i, k := range append(orders[1:], 0) {
+		switch {
+		case !kneserNey && k == 0:
+			t.tables = append(t.tables, &smoothingTable{k: 0, unigram: vocab})
+		case !kneserNey:
+			t.tables = append(t.tables, &smoothingTable{k: k, counts: m.counts})
+		case k == 0:
+			// Count the distinct contexts that each next n-gram follows
+			longer := orders[i]
+			continuations := make(map[string]uint32)
+			m.counts.walk(longer, func(path []string, count uint32) {
+				continuations[m.join(path[longer:])]++
+			})
+			t.tables = append(t.tables, &smoothingTable{k: 0, unigram: NewChooser(continuations)})
+		default:
+			// Count the distinct longer contexts that each next n-gram
+			// follows, once for every transition from them
+			longer := orders[i]
+			counts := newStore(Options{N: k, Mode: m.Options.Mode}, 0)
+			m.counts.walk(longer, func(path []string, count uint32) {
+				counts.add(k, path[longer-k:], 1)
+			})
+			counts.freeze()
+			t.tables = append(t.tables, &smoothingTable{k: k, counts: counts})
+		}
+	}
+
+	if kneserNey {
+		m.kneserNeyTables = t
+	} else {
+		m.tables = t
+	}
+	return t
+}
+
+// Vocabulary returns every next n-gram in the model, in sorted order. These are
+// the only n-grams that an Estimator gives a probability.
+func (m *Model) Vocabulary() []string {
+	return m.smoothingTables(Estimator{}).vocab
+}
+
+// Tokens normalizes text and splits it into tokens the same way training text
+// is, and lowercases them if Options.Lowercase is set.
+func (m *Model) Tokens(text string) []string {
+	return tokenize(m.Options.Normalize(text), m.Options, m.tokenizer)
+}
+
+// Prob returns the probability estimated by e that next follows context, the
+// tokens that precede it. Only the last N tokens of context are used. next is
+// an n-gram in NgramMode and a single token in TokenMode, with its tokens joined
+// like the keys of the model's Histogram.
+func (m *Model) Prob(e Estimator, context []string, next string) float64 {
+	return m.prob(e, m.smoothingTables(e), context, next)
+}
+
+func (m *Model) prob(e Estimator, t *smoothingTables, context []string, next string) float64 {
+	if e.Smoothing == MaximumLikelihood || e.Smoothing == AddK {
+		table := t.tables[0]
+		var frequency, total float64
+		if len(context) >= table.k {
+			frequency, total, _ = table.stats(m, context, next)
+		}
+		if e.Smoothing == AddK {
+			if !t.inVocabulary(next) {
+				return 0
+			}
+			return (frequency + e.k()) / (total + e.k()*float64(len(t.vocab)))
+		}
+		if total == 0 {
+			return 0
+		}
+		return frequency / total
+	}
+	if !t.inVocabulary(next) {
+		return 0
+	}
+	return m.interpolatedProb(e, t, 0, context, next)
+}
+
+func (t *smoothingTables) inVocabulary(next string) bool {
+	_, ok := t.index(next)
+	return ok
+}
+
+// index returns the index of next in the vocabulary
+func (t *smoothingTables) index(next string) (int, bool) {
+	i := sort.SearchStrings(t.vocab, next)
+	return i, i < len(t.vocab) && t.vocab[i] == next
+}
+
+// interpolatedProb returns the probability of next following context using the
+// ith table and every shorter one after it
+func (m *Model) interpolatedProb(e Estimator, t *smoothingTables, i int, context []string, next string) float64 {
+	lower := 1 / float64(len(t.vocab))
+	if i+1 < len(t.tables) {
+		lower = m.interpolatedProb(e, t, i+1, context, next)
+	}
+	table := t.tables[i]
+	if len(context) < table.k {
+		return lower
+	}
+	frequency, total, types := table.stats(m, context, next)
+	if total == 0 {
+		return lower
+	}
+	observed, backoff := e.interpolate(frequency, total, types)
+	return observed + backoff*lower
+}
+
+// interpolate splits the probability of an n-gram seen frequency times after a
+// context, of total frequency and types distinct next n-grams, into the part
+// estimated from its frequency and the weight of the shorter contexts
+func (e Estimator) interpolate(frequency, total, types float64) (observed, backoff float64) {
+	if e.Smoothing == WittenBell {
+		return frequency / (total + types), types / (total + types)
+	}
+	discount := e.discount()
+	return math.Max(frequency-discount, 0) / total, discount * types / total
+}
+
+// pickSmoothed uses rng to pick the n-gram following context from the
+// distribution estimated by e over the whole vocabulary, reshaped by s. Unless
+// s reshapes it, the distribution is sampled one context at a time, longest
+// first: a next n-gram seen after a context is drawn with the probability that
+// the context's frequencies give it, and the shorter contexts are drawn from
+// with the rest, so only the n-grams seen after each context are visited.
+func (m *Model) pickSmoothed(rng *rand.Rand, e Estimator, s Sampling, context []string) (string, bool) {
+	t := m.smoothingTables(e)
+	if len(t.vocab) == 0 {
+		return "", false
+	}
+	if !s.isProportional() {
+		return pickWeighted(rng, t.vocab, m.probs(e, t, context), s), true
+	}
+	tables := t.tables
+	if e.Smoothing == AddK {
+		// Add-k doesn't interpolate, and backs off to the uniform distribution
+		tables = tables[:1]
+	}
+	for _, table := range tables {
+		if len(context) < table.k {
+			continue
+		}
+		if table.k == 0 {
+			// The single context of order 0 is drawn from without visiting
+			// the whole vocabulary
+			unigram := table.unigram
+			if _, backoff := e.interpolate(0, float64(unigram.max), float64(len(unigram.items))); rng.Float64() >= backoff {
+				var discount float64
+				if e.Smoothing != WittenBell {
+					discount = e.discount()
+				}
+				return pickDiscounted(rng, unigram, discount), true
+			}
+			continue
+		}
+		items, weights, total := table.observed(m, context)
+		if total == 0 {
+			continue
+		}
+		types := float64(len(items))
+		var backoff float64
+		if e.Smoothing == AddK {
+			backoff = e.k() * float64(len(t.vocab)) / (total + e.k()*float64(len(t.vocab)))
+		} else {
+			_, backoff = e.interpolate(0, total, types)
+			for i, frequency := range weights {
+				weights[i], _ = e.interpolate(frequency, total, types)
+			}
+		}
+		if rng.Float64() >= backoff {
+			return pickWeighted(rng, items, weights, Sampling{}), true
+		}
+	}
+	return t.vocab[rng.Intn(len(t.vocab))], true
+}
+
+// pickDiscounted uses rng to pick an n-gram of c in proportion to its frequency
+// less discount, by rejecting each n-gram that c picks with the share of its
+// frequency that is discounted. Some n-gram of c must be more frequent than
+// discount.
+func pickDiscounted(rng *rand.Rand, c *Chooser, discount float64) string {
+	for {
+		i := c.pick(rng)
+		if frequency := float64(c.frequency(i)); rng.Float64()*frequency < frequency-discount {
+			return c.items[i]
+		}
+	}
+}
+
+// probs returns the probability estimated by e of every n-gram in the
+// vocabulary following context, in the order of the vocabulary. The tables are
+// interpolated from the shortest context to the longest.
+func (m *Model) probs(e Estimator, t *smoothingTables, context []string) []float64 {
+	probs := make([]float64, len(t.vocab))
+	if e.Smoothing == AddK {
+		var total float64
+		if table := t.tables[0]; len(context) >= table.k {
+			var items []string
+			var frequencies []float64
+			items, frequencies, total = table.observed(m, context)
+			for i, item := range items {
+				if v, ok := t.index(item); ok {
+					probs[v] = frequencies[i]
+				}
+			}
+		}
+		for v := range probs {
+			probs[v] = (probs[v] + e.k()) / (total + e.k()*float64(len(t.vocab)))
+		}
+		return probs
+	}
+	for v := range probs {
+		probs[v] = 1 / float64(len(t.vocab))
+	}
+	for i := len(t.tables) - 1; i >= 0; i-- {
+		table := t.tables[i]
+		if len(context) < table.k {
+			continue
+		}
+		items, frequencies, total := table.observed(m, context)
+		if total == 0 {
+			continue
+		}
+		types := float64(len(items))
+		_, backoff := e.interpolate(0, total, types)
+		for v := range probs {
+			probs[v] *= backoff
+		}
+		v := 0
+		for j, item := range items {
+			// Order 0 has every n-gram of the vocabulary in sorted order, so
+			// it is merged with the vocabulary instead of searched
+			ok := false
+			if table.k == 0 {
+				for v < len(t.vocab) && t.vocab[v] < item {
+					v++
+				}
+				ok = v < len(t.vocab) && t.vocab[v] == item
+			} else {
+				v, ok = t.index(item)
+			}
+			if ok {
+				observed, _ := e.interpolate(frequencies[j], total, types)
+				probs[v] += observed
+			}
+		}
+	}
+	return probs
+}
@@ -0,0 +1,179 @@
+package chain
+
+import (
+	"math"
+	"math/rand"
+	"strings"
+	"testing"
+)
+
+func TestModelProb(t *testing.T) {
+	model := New(Options{N: 1, Mode: TokenMode})
+	if err := model.Train(strings.NewReader("abab")); err != nil {
+		t.Fatalf("Train() error = %v", err)
+	}
+	tests := []struct {
+		name      string
+		estimator Estimator
+		context   string
+		next      string
+		want      float64
+	}{
+		{"MLE seen", Estimator{}, "a", "b", 1},
+		{"MLE unseen", Estimator{}, "a", "a", 0},
+		{"MLE unseen context", Estimator{}, "z", "a", 0},
+		{"Laplace", Estimator{Smoothing: AddK}, "a", "a", 1.0 / 4},
+		{"Add-k", Estimator{Smoothing: AddK, K: 0.5}, "a", "b", 2.5 / 3},
+		{"Add-k unseen context", Estimator{Smoothing: AddK}, "z", "a", 1.0 / 2},
+		{"Witten-Bell", Estimator{Smoothing: WittenBell}, "a", "a", 2.0 / 15},
+		{"Absolute discounting", Estimator{Smoothing: AbsoluteDiscounting, Discount: 0.5}, "a", "a", 1.0 / 12},
+		{"Kneser-Ney", Estimator{Smoothing: KneserNey, Discount: 0.5}, "a", "a", 1.0 / 8},
+		{"Kneser-Ney unseen context", Estimator{Smoothing: KneserNey, Discount: 0.5}, "z", "a", 1.0 / 2},
+		{"Out of vocabulary", Estimator{Smoothing: KneserNey}, "a", "z", 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := model.Prob(tt.estimator, model.Tokens(tt.context), tt.next); math.Abs(got-tt.want) > 1e-9 {
+				t.Errorf("Prob() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestModelProbSumsToOne(t *testing.T) {
+	text := "the cat sat on the mat and the dog sat on the cat"
+	tests := []struct {
+		name string
+		opts Options
+	}{
+		{"Characters", Options{N: 2}},
+		{"Characters with backoff", Options{N: 3, Backoff: true}},
+		{"Tokens with backoff", Options{N: 2, Mode: TokenMode, Backoff: true}},
+		{"Words", Options{N: 1, Mode: TokenMode, Words: true}},
+	}
+	contexts := []string{"the cat sat", "zzz", "he mat"}
+	for _, tt := range tests {
+		model := New(tt.opts)
+		if err := model.Train(strings.NewReader(text)); err != nil {
+			t.Fatalf("Train() error = %v", err)
+		}
+		for _, smoothing := range []Smoothing{AddK, WittenBell, AbsoluteDiscounting, KneserNey} {
+			for _, context := range contexts {
+				var total float64
+				for _, next := range model.Vocabulary() {
+					p := model.Prob(Estimator{Smoothing: smoothing}, model.Tokens(context), next)
+					if p <= 0 {
+						t.Errorf("%s: %v Prob(%q, %q) = %v, want > 0", tt.name, smoothing, context, next, p)
+					}
+					total += p
+				}
+				if math.Abs(total-1) > 1e-9 {
+					t.Errorf("%s: %v probabilities after %q sum to %v, want 1", tt.name, smoothing, context, total)
+				}
+			}
+		}
+	}
+}
+
+func TestModelPickSmoothed(t *testing.T) {
+	text := "the cat sat on the mat and the dog sat on the cat"
+	tests := []struct {
+		name string
+		opts Options
+	}{
+		{"Characters", Options{N: 2}},
+		{"Characters with backoff", Options{N: 3, Backoff: true}},
+		{"Tokens with backoff", Options{N: 2, Mode: TokenMode, Backoff: true}},
+	}
+	contexts := []string{"the cat sat", "zzz", "he mat"}
+	for _, tt := range tests {
+		model := New(tt.opts)
+		if err := model.Train(strings.NewReader(text)); err != nil {
+			t.Fatalf("Train() error = %v", err)
+		}
+		for _, smoothing := range []Smoothing{AddK, WittenBell, AbsoluteDiscounting, KneserNey} {
+			e := Estimator{Smoothing: smoothing}
+			tables := model.smoothingTables(e)
+			for _, context := range contexts {
+				tokens := model.Tokens(context)
+				// The distribution that reshaped sampling draws from is Prob's
+				probs := model.probs(e, tables, tokens)
+				for i, next := range tables.vocab {
+					if want := model.Prob(e, tokens, next); math.Abs(probs[i]-want) > 1e-9 {
+						t.Errorf("%s: %v probs(%q)[%q] = %v, want %v", tt.name, smoothing, context, next, probs[i], want)
+					}
+				}
+				// And so is the one that proportional sampling draws from
+				const samples = 20000
+				counts := make(map[string]int)
+				rng := rand.New(rand.NewSource(1))
+				for i := 0; i < samples; i++ {
+					next, _ := model.pickSmoothed(rng, e, Sampling{}, tokens)
+					counts[next]++
+				}
+				for i, next := range tables.vocab {
+					if got := float64(counts[next]) / samples; math.Abs(got-probs[i]) > 0.015 {
+						t.Errorf("%s: %v picked %q after %q %v of the time, want %v", tt.name, smoothing, next, context, got, probs[i])
+					}
+				}
+			}
+		}
+	}
+}
+
+func TestGeneratorSmoothing(t *testing.T) {
+	model := New(Options{N: 2})
+	if err := model.Train(strings.NewReader("abcdefg")); err != nil {
+		t.Fatalf("Train() error = %v", err)
+	}
+	for _, smoothing := range []Smoothing{AddK, WittenBell, AbsoluteDiscounting, KneserNey} {
+		generator := NewGenerator(model, 1)
+		generator.Estimator = Estimator{Smoothing: smoothing}
+		got := generator.Generate("zz", 6)
+		// Neither the unseen prompt nor the end of the corpus is a dead end
+		if !strings.HasPrefix(got, "zz") || len(got) != 8 {
+			t.Errorf("%v: Generate() = %q, want the prompt and 6 more characters", smoothing, got)
+		}
+		again := NewGenerator(model, 1)
+		again.Estimator = generator.Estimator
+		if repeat := again.Generate("zz", 6); repeat != got {
+			t.Errorf("%v: Generate() = %q, then %q with the same seed", smoothing, got, repeat)
+		}
+	}
+}
+
+func TestParseSmoothing(t *testing.T) {
+	for _, want := range []Smoothing{MaximumLikelihood, AddK, WittenBell, AbsoluteDiscounting, KneserNey} {
+		got, err := ParseSmoothing(want.String())
+		if err != nil || got != want {
+			t.Errorf("ParseSmoothing(%q) = %v, %v, want %v", want.String(), got, err, want)
+		}
+	}
+	if got, err := ParseSmoothing("laplace"); err != nil || got != AddK {
+		t.Errorf("ParseSmoothing(\"laplace\") = %v, %v, want %v", got, err, AddK)
+	}
+	if _, err := ParseSmoothing("good-turing"); err == nil {
+		t.Errorf("ParseSmoothing() error = nil, want error")
+	}
+}
+
+func TestEstimatorValidate(t *testing.T) {
+	tests := []struct {
+		name      string
+		estimator Estimator
+		wantErr   bool
+	}{
+		{"Zero", Estimator{}, false},
+		{"Valid", Estimator{Smoothing: KneserNey, Discount: 0.5}, false},
+		{"Unknown smoothing", Estimator{Smoothing: Smoothing(10)}, true},
+		{"Negative k", Estimator{Smoothing: AddK, K: -1}, true},
+		{"Discount above 1", Estimator{Smoothing: AbsoluteDiscounting, Discount: 1.5}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.estimator.Validate(); (err != nil) != tt.wantErr {
+				t.Errorf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
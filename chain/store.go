@@ -0,0 +1,478 @@
+package chain
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+)
+
+// ErrMemoryLimit is returned by Train when counting the corpus would use more
+// memory than the Model's MemoryLimit.
+var ErrMemoryLimit = errors.New("model error: memory limit exceeded")
+
+const (
+	// nodeBytes is roughly the memory each trie node uses while it is counted
+	// and frozen
+	nodeBytes = 40
+	// tokenBytes is roughly the memory each interned token uses besides its text
+	tokenBytes = 48
+)
+
+// counter is the read-only view of the transitions counted by a Model, which
+// are either held in a store or queried in place in a mapped model file
+type counter interface {
+	// counted reports whether the transitions from contexts of k tokens are
+	// counted
+	counted(k int) bool
+	has(k int, context []string) bool
+	next(context []string, fn func(next []string, count uint32))
+	walk(k int, fn func(path []string, count uint32))
+	contexts(k int) int
+	context(k, i int) []string
+}
+
+// store holds the transitions counted by a Model. Every token is interned as
+// an id, and the transitions from the contexts of each length are kept in a
+// trie of token ids, the tokens of the context followed by those of the next
+// n-gram, so that n-grams sharing a prefix share its nodes instead of each
+// holding a copy of its text. Tries are counted in a hash table of edges, then
+// frozen into sorted arrays that take a fraction of the memory and are
+// searched by binary search.
+type store struct {
+	n    int
+	mode Mode
+	// tokens holds the text of each id. Once frozen, ids are in sorted order.
+	tokens []string
+	// ids interns tokens while counting
+	ids map[string]uint32
+	// tries[k-1] holds the transitions from contexts of k tokens, or nil if
+	// they aren't counted
+	tries []*trie
+	// limit is the most memory counting may use, or 0 for no limit, and used
+	// estimates the memory used so far
+	limit, used int64
+	// path holds the ids of the transition being added
+	path []uint32
+}
+
+// newStore returns an empty store that counts the transitions chosen by opts
+// in at most limit bytes, or without limit if limit is 0
+func newStore(opts Options, limit int64) *store {
+	s := &store{n: opts.N, mode: opts.Mode, ids: make(map[string]uint32), tries: make([]*trie, opts.N), limit: limit}
+	for k := 1; k <= opts.N; k++ {
+		if k == opts.N || opts.Backoff {
+			s.tries[k-1] = newTrie()
+		}
+	}
+	return s
+}
+
+// emptyStore returns a frozen store with no transitions
+func emptyStore(opts Options) *store {
+	s := newStore(opts, 0)
+	s.freeze()
+	return s
+}
+
+// trie returns the trie of contexts of k tokens, or nil if there is none
+func (s *store) trie(k int) *trie {
+	if k < 1 || k > len(s.tries) {
+		return nil
+	}
+	return s.tries[k-1]
+}
+
+// counted reports whether s has a trie of contexts of k tokens
+func (s *store) counted(k int) bool {
+	return s.trie(k) != nil
+}
+
+// storeOf returns the store holding the transitions of c, which it builds
+// with opts if c is not a store itself
+func storeOf(c counter, opts Options) *store {
+	if s, ok := c.(*store); ok {
+		return s
+	}
+	s, _ := thaw(c, opts, 0)
+	s.freeze()
+	return s
+}
+
+// thaw returns a store that counts the transitions of c, with opts, in at most
+// limit bytes. It has the same tries as c, so that counting more transitions
+// into it doesn't add shorter contexts that c doesn't have.
+func thaw(c counter, opts Options, limit int64) (*store, error) {
+	s := newStore(Options{N: opts.N, Mode: opts.Mode, Backoff: true}, limit)
+	for k := 1; k <= opts.N; k++ {
+		if !c.counted(k) {
+			s.tries[k-1] = nil
+			continue
+		}
+		var err error
+		c.walk(k, func(path []string, count uint32) {
+			if err == nil {
+				err = s.add(k, path, count)
+			}
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+// addWindow counts the transition from the first n tokens of window to the
+// n-gram, or in TokenMode the token, after them, and from every shorter
+// context that has a trie
+func (s *store) addWindow(window []string) error {
+	next := window[s.n : s.n+1]
+	if s.mode == NgramMode {
+		next = window[s.n : s.n*2]
+	}
+	// The path from the last k tokens of the context is the end of the path
+	// from all n of them
+	path := s.intern(s.intern(s.path[:0], window[:s.n]), next)
+	s.path = path
+	for k := s.n; k >= 1; k-- {
+		if err := s.addPath(k, path[s.n-k:], 1); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// add adds count to the transition from the first k tokens of path to the rest
+func (s *store) add(k int, path []string, count uint32) error {
+	s.path = s.intern(s.path[:0], path)
+	return s.addPath(k, s.path, count)
+}
+
+// merge adds the counts of other, which must not be frozen, to s
+func (s *store) merge(other *store) error {
+	ids := s.intern(nil, other.tokens)
+	for i, t := range other.tries {
+		if t == nil {
+			continue
+		}
+		// Nodes are numbered in the order they are added, so every parent is
+		// merged before its children
+		parents := make([]edge, len(t.counts))
+		for e, node := range t.edges {
+			parents[node] = e
+		}
+		nodes := make([]uint32, len(t.counts))
+		dst := s.tries[i]
+		for node := 1; node < len(t.counts); node++ {
+			e := parents[node]
+			child, added := dst.child(nodes[e.parent], ids[e.token])
+			nodes[node] = child
+			dst.counts[child] += t.counts[node]
+			if added {
+				s.used += nodeBytes
+			}
+		}
+		if s.limit > 0 && s.used > s.limit {
+			return s.limitError()
+		}
+	}
+	return nil
+}
+
+// intern appends the ids of tokens to ids, adding any new tokens
+func (s *store) intern(ids []uint32, tokens []string) []uint32 {
+	for _, token := range tokens {
+		id, ok := s.ids[token]
+		if !ok {
+			id = uint32(len(s.tokens))
+			s.ids[token] = id
+			s.tokens = append(s.tokens, token)
+			s.used += int64(len(token)) + tokenBytes
+		}
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+// addPath adds count to path in the trie of contexts of k tokens, if there is
+// one
+func (s *store) addPath(k int, path []uint32, count uint32) error {
+	t := s.trie(k)
+	if t == nil {
+		return nil
+	}
+	s.used += int64(t.add(path, count)) * nodeBytes
+	if s.limit > 0 && s.used > s.limit {
+		return s.limitError()
+	}
+	return nil
+}
+
+func (s *store) limitError() error {
+	return fmt.Errorf("%w: counting the corpus needs more than %d MiB, use a shorter n-gram length or a higher limit", ErrMemoryLimit, s.limit>>20)
+}
+
+// freeze sorts the tokens and freezes every trie. The store can't be added to
+// once frozen.
+func (s *store) freeze() {
+	order := make([]uint32, len(s.tokens))
+	for i := range order {
+		order[i] = uint32(i)
+	}
+	sort.Slice(order, func(i, j int) bool { return s.tokens[order[i]] < s.tokens[order[j]] })
+	rank := make([]uint32, len(order))
+	sorted := make([]string, len(order))
+	for i, id := range order {
+		rank[id] = uint32(i)
+		sorted[i] = s.tokens[id]
+	}
+	s.tokens, s.ids = sorted, nil
+	for _, t := range s.tries {
+		if t != nil {
+			t.freeze(rank)
+		}
+	}
+}
+
+// lookup returns the ids of tokens in a frozen store. ok is false if any of
+// them has none.
+func (s *store) lookup(tokens []string) (ids []uint32, ok bool) {
+	ids = make([]uint32, len(tokens))
+	for i, token := range tokens {
+		j := sort.SearchStrings(s.tokens, token)
+		if j == len(s.tokens) || s.tokens[j] != token {
+			return nil, false
+		}
+		ids[i] = uint32(j)
+	}
+	return ids, true
+}
+
+// has reports whether context, of k tokens, has any next n-grams
+func (s *store) has(k int, context []string) bool {
+	t := s.trie(k)
+	if t == nil || len(context) != k {
+		return false
+	}
+	path, ok := s.lookup(context)
+	if !ok {
+		return false
+	}
+	_, ok = t.find(path)
+	return ok
+}
+
+// walk calls fn with the tokens and count of every transition from contexts
+// of k tokens, in sorted order. path is reused after fn returns.
+func (s *store) walk(k int, fn func(path []string, count uint32)) {
+	t := s.trie(k)
+	if t == nil || len(t.levels) == 0 {
+		return
+	}
+	var tokens []string
+	t.walk(0, 0, len(t.levels[0].tokens), nil, func(path []uint32, count uint32) {
+		tokens = tokens[:0]
+		for _, id := range path {
+			tokens = append(tokens, s.tokens[id])
+		}
+		fn(tokens, count)
+	})
+}
+
+// next calls fn with the tokens and count of every next n-gram of context, in
+// sorted order. next is reused after fn returns.
+func (s *store) next(context []string, fn func(next []string, count uint32)) {
+	k := len(context)
+	t := s.trie(k)
+	if t == nil || k == 0 {
+		return
+	}
+	path, ok := s.lookup(context)
+	if !ok {
+		return
+	}
+	i, ok := t.find(path)
+	if !ok {
+		return
+	}
+	children := t.levels[k-1].children
+	var tokens []string
+	t.walk(k, int(children[i]), int(children[i+1]), path, func(path []uint32, count uint32) {
+		tokens = tokens[:0]
+		for _, id := range path[k:] {
+			tokens = append(tokens, s.tokens[id])
+		}
+		fn(tokens, count)
+	})
+}
+
+// contexts returns the number of contexts of k tokens
+func (s *store) contexts(k int) int {
+	t := s.trie(k)
+	if t == nil || len(t.levels) < k {
+		return 0
+	}
+	return len(t.levels[k-1].tokens)
+}
+
+// context returns the tokens of the ith context of k tokens in sorted order
+func (s *store) context(k, i int) []string {
+	t := s.trie(k)
+	tokens := make([]string, k)
+	for d := k - 1; d >= 0; d-- {
+		tokens[d] = s.tokens[t.levels[d].tokens[i]]
+		if d > 0 {
+			children := t.levels[d-1].children
+			i = sort.Search(len(children)-1, func(p int) bool { return int(children[p+1]) > i })
+		}
+	}
+	return tokens
+}
+
+// edge is a child of the parent node, reached by a token id
+type edge struct {
+	parent, token uint32
+}
+
+// trie counts paths of token ids. While counting, nodes are numbered in the
+// order they are added, with 0 as the root. Frozen tries keep their nodes in
+// levels instead.
+type trie struct {
+	edges  map[edge]uint32
+	counts []uint32
+	// levels[d] holds the nodes at depth d+1 once frozen
+	levels []level
+}
+
+// level holds the nodes at one depth of a frozen trie, ordered by their
+// parent and then by token, so that the nodes of each depth are in sorted
+// order
+type level struct {
+	tokens []uint32
+	// counts holds the number of paths that end at each node
+	counts []uint32
+	// children[i] is where the children of the ith node start in the next
+	// level, and children[i+1] is where they end
+	children []uint32
+}
+
+func newTrie() *trie {
+	return &trie{edges: make(map[edge]uint32), counts: []uint32{0}}
+}
+
+// add adds count to path and returns the number of nodes added for it
+func (t *trie) add(path []uint32, count uint32) int {
+	node, added := uint32(0), 0
+	for _, token := range path {
+		child, isNew := t.child(node, token)
+		if isNew {
+			added++
+		}
+		node = child
+	}
+	t.counts[node] += count
+	return added
+}
+
+// child returns the child of node reached by token, and whether it was added
+func (t *trie) child(node, token uint32) (child uint32, added bool) {
+	child, ok := t.edges[edge{node, token}]
+	if !ok {
+		child = uint32(len(t.counts))
+		t.edges[edge{node, token}] = child
+		t.counts = append(t.counts, 0)
+	}
+	return child, !ok
+}
+
+// freeze lays the nodes of t out in levels, with their tokens replaced by
+// rank[token]
+func (t *trie) freeze(rank []uint32) {
+	type child struct {
+		edge
+		node uint32
+	}
+	// Group the children of each node, in token order
+	children := make([]child, 0, len(t.edges))
+	for e, node := range t.edges {
+		children = append(children, child{edge{e.parent, rank[e.token]}, node})
+	}
+	t.edges = nil
+	sort.Slice(children, func(i, j int) bool {
+		if children[i].parent != children[j].parent {
+			return children[i].parent < children[j].parent
+		}
+		return children[i].token < children[j].token
+	})
+	start := make([]uint32, len(t.counts)+1)
+	for _, c := range children {
+		start[c.parent+1]++
+	}
+	for i := 1; i < len(start); i++ {
+		start[i] += start[i-1]
+	}
+
+	t.levels = nil
+	frontier := []uint32{0}
+	for {
+		var next []uint32
+		var l level
+		offsets := make([]uint32, 0, len(frontier)+1)
+		for _, node := range frontier {
+			offsets = append(offsets, uint32(len(next)))
+			for _, c := range children[start[node]:start[node+1]] {
+				next = append(next, c.node)
+				l.tokens = append(l.tokens, c.token)
+				l.counts = append(l.counts, t.counts[c.node])
+			}
+		}
+		offsets = append(offsets, uint32(len(next)))
+		if len(t.levels) > 0 {
+			t.levels[len(t.levels)-1].children = offsets
+		}
+		if len(next) == 0 {
+			break
+		}
+		t.levels = append(t.levels, l)
+		frontier = next
+	}
+	t.counts = nil
+}
+
+// find returns the index of the node at the end of path in its level
+func (t *trie) find(path []uint32) (int, bool) {
+	lo, hi := 0, 0
+	if len(t.levels) > 0 {
+		hi = len(t.levels[0].tokens)
+	}
+	for d, token := range path {
+		if d >= len(t.levels) {
+			return 0, false
+		}
+		l := &t.levels[d]
+		i := lo + sort.Search(hi-lo, func(j int) bool { return l.tokens[lo+j] >= token })
+		if i == hi || l.tokens[i] != token {
+			return 0, false
+		}
+		if d == len(path)-1 {
+			return i, true
+		}
+		lo, hi = int(l.children[i]), int(l.children[i+1])
+	}
+	return 0, false
+}
+
+// walk calls fn with every path through the nodes lo to hi of the level at
+// depth d+1 that ends at a node with a count, after prefix
+func (t *trie) walk(d, lo, hi int, prefix []uint32, fn func(path []uint32, count uint32)) {
+	if d >= len(t.levels) {
+		return
+	}
+	l := &t.levels[d]
+	for i := lo; i < hi; i++ {
+		path := append(prefix[:d], l.tokens[i])
+		if l.counts[i] > 0 {
+			fn(path, l.counts[i])
+		}
+		t.walk(d+1, int(l.children[i]), int(l.children[i+1]), path, fn)
+	}
+}
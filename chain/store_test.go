@@ -0,0 +1,78 @@
+package chain
+
+import (
+	"errors"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestStore(t *testing.T) {
+	text := "the cat sat on the mat. the cat ate the rat, and the rat sat on the cat."
+	tests := []struct {
+		name string
+		opts Options
+	}{
+		{"Characters", Options{N: 3}},
+		{"Words", Options{N: 2, Words: true}},
+		{"Token mode", Options{N: 3, Mode: TokenMode, Words: true}},
+		{"Long n-grams", Options{N: 12}},
+		{"Long token n-grams", Options{N: 10, Mode: TokenMode, Backoff: true}},
+		{"Segments", Options{N: 2, Mode: TokenMode, Words: true, Segment: SegmentSentences}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			model := New(tt.opts)
+			if err := model.Train(strings.NewReader(text)); err != nil {
+				t.Fatalf("Train() error = %v", err)
+			}
+			// The store counts the same transitions as a histogram of strings
+			want := make(StringHistogram)
+			err := countTransitions(strings.NewReader(text), tt.opts, model.tokenizer, func(window []string) error {
+				countWindow(window, tt.opts.N, tt.opts.Mode, model.tokenizer.Separator(), want)
+				return nil
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(model.Histogram(), want) {
+				t.Errorf("Histogram() = %v, want %v", model.Histogram(), want)
+			}
+
+			var contexts []string
+			for gram := range want {
+				contexts = append(contexts, gram)
+			}
+			if got := model.counts.contexts(tt.opts.N); got != len(contexts) {
+				t.Fatalf("contexts() = %d, want %d", got, len(contexts))
+			}
+			var got []string
+			for i := 0; i < len(contexts); i++ {
+				context := model.counts.context(tt.opts.N, i)
+				if !model.has(context) {
+					t.Errorf("has(%q) = false, want true", context)
+				}
+				got = append(got, model.join(context))
+			}
+			sort.Strings(contexts)
+			sort.Strings(got)
+			if !reflect.DeepEqual(got, contexts) {
+				t.Errorf("context() = %q, want %q", got, contexts)
+			}
+		})
+	}
+}
+
+func TestModelMemoryLimit(t *testing.T) {
+	text := strings.Repeat("the quick brown fox jumps over the lazy dog ", 100) + "and so on and so forth"
+	model := New(Options{N: 10})
+	model.MemoryLimit = 1 << 12
+	if err := model.Train(strings.NewReader(text)); !errors.Is(err, ErrMemoryLimit) {
+		t.Errorf("Train() error = %v, want %v", err, ErrMemoryLimit)
+	}
+	model.MemoryLimit = 1 << 20
+	if err := model.Train(strings.NewReader(text)); err != nil {
+		t.Errorf("Train() error = %v", err)
+	}
+}
@@ -0,0 +1,362 @@
+package chain
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/rivo/uniseg"
+)
+
+// Tokenizer splits text into the tokens a Model counts.
+type Tokenizer interface {
+	// Name identifies the tokenizer in model files, in the form accepted by
+	// ParseTokenizer.
+	Name() string
+	// Split is a bufio.SplitFunc that scans the next token of the text.
+	Split(data []byte, atEOF bool) (advance int, token []byte, err error)
+	// Separator joins the tokens of each n-gram in a model's histogram. Tokens
+	// never contain it. If it is empty, n-grams are split back into tokens with
+	// Split.
+	Separator() string
+	// Detokenizer is the name of the Detokenizer that joins the tokens back
+	// into text by default.
+	Detokenizer() string
+}
+
+// Detokenizer joins generated tokens back into text.
+type Detokenizer interface {
+	// Name identifies the detokenizer in model files, in the form accepted by
+	// ParseDetokenizer.
+	Name() string
+	// Separator returns the text written between the tokens prev and next.
+	Separator(prev, next string) string
+}
+
+// ParseTokenizer returns the Tokenizer named name:
+//
+//	runes        each Unicode code point
+//	graphemes    each user-perceived character, e.g. a letter and its accents
+//	words        each run of non-whitespace
+//	punctuation  each word, and each punctuation mark or symbol that isn't
+//	             between two letters or digits
+//	whitespace   like punctuation, and each run of whitespace between them, so
+//	             that line breaks and indentation are generated too
+//	bytes        each byte
+//	regex:<re>   each match of the regular expression <re>, on one line
+//	bpe:<n>      each subword learned by n merges of byte-pair encoding, or
+//	             DefaultMerges if only "bpe" is given
+//
+// The bpe tokenizer learns its subwords from the text a Model is trained on,
+// so Train reads the whole corpus into memory before counting it.
+func ParseTokenizer(name string) (Tokenizer, error) {
+	if name == "bpe" || strings.HasPrefix(name, "bpe:") {
+		return newBPETokenizer(name)
+	}
+	if strings.HasPrefix(name, "regex:") {
+		re, err := regexp.Compile(strings.TrimPrefix(name, "regex:"))
+		if err != nil {
+			return nil, fmt.Errorf("invalid tokenizer %q: %v", name, err)
+		}
+		return regexTokenizer{re}, nil
+	}
+	for _, tokenizer := range tokenizers {
+		if tokenizer.Name() == name {
+			return tokenizer, nil
+		}
+	}
+	return nil, fmt.Errorf("unknown tokenizer %q, must be one of runes, graphemes, words, punctuation, whitespace, bytes, regex:<pattern> or bpe:<merges>", name)
+}
+
+// ParseDetokenizer returns the Detokenizer named name:
+//
+//	concat       writes the tokens one after another
+//	space        writes a space between tokens
+//	punctuation  writes a space between tokens, except before closing
+//	             punctuation and after opening punctuation
+func ParseDetokenizer(name string) (Detokenizer, error) {
+	for _, detokenizer := range detokenizers {
+		if detokenizer.Name() == name {
+			return detokenizer, nil
+		}
+	}
+	return nil, fmt.Errorf("unknown detokenizer %q, must be one of concat, space, punctuation", name)
+}
+
+var (
+	tokenizers   = []Tokenizer{runesTokenizer{}, graphemesTokenizer{}, wordsTokenizer{}, punctuationTokenizer{}, whitespaceTokenizer{}, bytesTokenizer{}}
+	detokenizers = []Detokenizer{concatDetokenizer{}, spaceDetokenizer{}, punctuationDetokenizer{}}
+)
+
+// GetTokenizerName returns "words" if words is true, "runes" otherwise
+func GetTokenizerName(words bool) string {
+	if words {
+		return "words"
+	}
+	return "runes"
+}
+
+// tokenizerName returns the name of the Tokenizer chosen by opts
+func (opts Options) tokenizerName() string {
+	if opts.Tokenizer != "" {
+		return opts.Tokenizer
+	}
+	return GetTokenizerName(opts.Words)
+}
+
+// tokenizers returns the Tokenizer and Detokenizer chosen by opts
+func (opts Options) tokenizers() (Tokenizer, Detokenizer, error) {
+	tokenizer, err := ParseTokenizer(opts.tokenizerName())
+	if err != nil {
+		return nil, nil, err
+	}
+	name := opts.Detokenizer
+	if name == "" {
+		name = tokenizer.Detokenizer()
+	}
+	detokenizer, err := ParseDetokenizer(name)
+	if err != nil {
+		return nil, nil, err
+	}
+	return tokenizer, detokenizer, nil
+}
+
+// splitTokens splits text into tokens with split
+func splitTokens(split bufio.SplitFunc, text string) []string {
+	data := []byte(text)
+	var tokens []string
+	for len(data) > 0 {
+		advance, token, err := split(data, true)
+		if err != nil || advance <= 0 {
+			break
+		}
+		if token != nil {
+			tokens = append(tokens, string(token))
+		}
+		data = data[advance:]
+	}
+	return tokens
+}
+
+type runesTokenizer struct{}
+
+func (runesTokenizer) Name() string        { return "runes" }
+func (runesTokenizer) Separator() string   { return "" }
+func (runesTokenizer) Detokenizer() string { return "concat" }
+
+func (runesTokenizer) Split(data []byte, atEOF bool) (int, []byte, error) {
+	return bufio.ScanRunes(data, atEOF)
+}
+
+type graphemesTokenizer struct{}
+
+func (graphemesTokenizer) Name() string        { return "graphemes" }
+func (graphemesTokenizer) Separator() string   { return "" }
+func (graphemesTokenizer) Detokenizer() string { return "concat" }
+
+func (graphemesTokenizer) Split(data []byte, atEOF bool) (int, []byte, error) {
+	if len(data) == 0 {
+		return 0, nil, nil
+	}
+	// Only segment as much of data as it takes to find the end of the first
+	// grapheme cluster
+	for size := 64; ; size *= 2 {
+		end := size
+		if end > len(data) {
+			end = len(data)
+		}
+		g := uniseg.NewGraphemes(string(data[:end]))
+		g.Next()
+		_, to := g.Positions()
+		// The boundary depends on the rune after it, which must be complete
+		if (to < end && utf8.FullRune(data[to:end])) || (end == len(data) && atEOF) {
+			return to, data[:to], nil
+		}
+		if end == len(data) {
+			return 0, nil, nil
+		}
+	}
+}
+
+type wordsTokenizer struct{}
+
+func (wordsTokenizer) Name() string        { return "words" }
+func (wordsTokenizer) Separator() string   { return " " }
+func (wordsTokenizer) Detokenizer() string { return "space" }
+
+func (wordsTokenizer) Split(data []byte, atEOF bool) (int, []byte, error) {
+	return bufio.ScanWords(data, atEOF)
+}
+
+type punctuationTokenizer struct{}
+
+func (punctuationTokenizer) Name() string        { return "punctuation" }
+func (punctuationTokenizer) Separator() string   { return " " }
+func (punctuationTokenizer) Detokenizer() string { return "punctuation" }
+
+func (punctuationTokenizer) Split(data []byte, atEOF bool) (int, []byte, error) {
+	advance, word, err := bufio.ScanWords(data, atEOF)
+	if word == nil || err != nil {
+		return advance, word, err
+	}
+	// Only whitespace comes before the word, so this is where it starts
+	start := bytes.Index(data, word)
+	r, size := utf8.DecodeRune(word)
+	if isPunctuation(r) {
+		return start + size, word[:size], nil
+	}
+	// The word ends at the first punctuation mark that isn't between two
+	// letters or digits
+	prev := r
+	for i := size; i < len(word); {
+		r, size := utf8.DecodeRune(word[i:])
+		if isPunctuation(r) {
+			next, _ := utf8.DecodeRune(word[i+size:])
+			if i+size == len(word) || !isAlphanumeric(prev) || !isAlphanumeric(next) {
+				return start + i, word[:i], nil
+			}
+		}
+		prev = r
+		i += size
+	}
+	return advance, word, nil
+}
+
+func isPunctuation(r rune) bool {
+	return unicode.IsPunct(r) || unicode.IsSymbol(r)
+}
+
+func isAlphanumeric(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r)
+}
+
+type whitespaceTokenizer struct{}
+
+func (whitespaceTokenizer) Name() string        { return "whitespace" }
+func (whitespaceTokenizer) Separator() string   { return unitSeparator }
+func (whitespaceTokenizer) Detokenizer() string { return "concat" }
+
+func (whitespaceTokenizer) Split(data []byte, atEOF bool) (int, []byte, error) {
+	for i := 0; i < len(data); {
+		if !atEOF && !utf8.FullRune(data[i:]) {
+			return 0, nil, nil
+		}
+		r, size := utf8.DecodeRune(data[i:])
+		if !unicode.IsSpace(r) {
+			if i > 0 {
+				return i, data[:i], nil
+			}
+			// The word or punctuation mark starts data, so it ends where its
+			// token does, before any whitespace after it
+			_, token, err := punctuationTokenizer{}.Split(data, atEOF)
+			if token == nil || err != nil {
+				return 0, nil, err
+			}
+			return len(token), withoutUnitSeparators(token), nil
+		}
+		i += size
+	}
+	// The run of whitespace may continue
+	if !atEOF || len(data) == 0 {
+		return 0, nil, nil
+	}
+	return len(data), data, nil
+}
+
+type bytesTokenizer struct{}
+
+func (bytesTokenizer) Name() string        { return "bytes" }
+func (bytesTokenizer) Separator() string   { return "" }
+func (bytesTokenizer) Detokenizer() string { return "concat" }
+
+func (bytesTokenizer) Split(data []byte, atEOF bool) (int, []byte, error) {
+	if len(data) == 0 {
+		return 0, nil, nil
+	}
+	return 1, data[:1], nil
+}
+
+// unitSeparator joins the tokens of tokenizers whose tokens may contain spaces
+const unitSeparator = "\x1f"
+
+type regexTokenizer struct {
+	re *regexp.Regexp
+}
+
+func (t regexTokenizer) Name() string      { return "regex:" + t.re.String() }
+func (regexTokenizer) Separator() string   { return unitSeparator }
+func (regexTokenizer) Detokenizer() string { return "space" }
+
+func (t regexTokenizer) Split(data []byte, atEOF bool) (int, []byte, error) {
+	// Tokens never span lines, so text that doesn't match can be skipped a line
+	// at a time
+	line := data
+	complete := atEOF
+	if i := bytes.IndexByte(data, '\n'); i >= 0 {
+		line, complete = data[:i+1], true
+	}
+	loc := t.re.FindIndex(line)
+	switch {
+	case loc == nil && complete:
+		return len(line), nil, nil
+	case loc == nil || (loc[1] == len(line) && !complete):
+		// The match may continue, or a match may start, in the rest of the line
+		return 0, nil, nil
+	case loc[0] == loc[1]:
+		// Skip empty matches
+		_, size := utf8.DecodeRune(line[loc[1]:])
+		if loc[1] == len(line) {
+			return len(line), nil, nil
+		}
+		return loc[1] + size, nil, nil
+	}
+	return loc[1], withoutUnitSeparators(line[loc[0]:loc[1]]), nil
+}
+
+// withoutUnitSeparators removes unitSeparator from token, and returns nil if
+// nothing is left
+func withoutUnitSeparators(token []byte) []byte {
+	if bytes.Contains(token, []byte(unitSeparator)) {
+		token = bytes.Replace(token, []byte(unitSeparator), nil, -1)
+	}
+	if len(token) == 0 {
+		return nil
+	}
+	return token
+}
+
+type concatDetokenizer struct{}
+
+func (concatDetokenizer) Name() string                       { return "concat" }
+func (concatDetokenizer) Separator(prev, next string) string { return "" }
+
+type spaceDetokenizer struct{}
+
+func (spaceDetokenizer) Name() string                       { return "space" }
+func (spaceDetokenizer) Separator(prev, next string) string { return " " }
+
+type punctuationDetokenizer struct{}
+
+const (
+	openingPunctuation = "([{¿¡«“‘"
+	closingPunctuation = ".,;:!?)]}%»”’…"
+)
+
+func (punctuationDetokenizer) Name() string { return "punctuation" }
+
+func (punctuationDetokenizer) Separator(prev, next string) string {
+	if isMarkOf(closingPunctuation, next) || isMarkOf(openingPunctuation, prev) {
+		return ""
+	}
+	return " "
+}
+
+// isMarkOf reports whether token is a single one of the punctuation marks
+func isMarkOf(punctuation, token string) bool {
+	r, size := utf8.DecodeRuneInString(token)
+	return size == len(token) && r != utf8.RuneError && strings.ContainsRune(punctuation, r)
+}
@@ -0,0 +1,136 @@
+package chain
+
+import (
+	"bufio"
+	"reflect"
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+func TestTokenizers(t *testing.T) {
+	tests := []struct {
+		name      string
+		tokenizer string
+		text      string
+		want      []string
+		detokens  string
+	}{
+		{"Runes", "runes", "aé b", []string{"a", "e", "́", " ", "b"}, "aé b"},
+		{"Graphemes", "graphemes", "aé 👍🏽\r\n", []string{"a", "é", " ", "👍🏽", "\r\n"}, "aé 👍🏽\r\n"},
+		{"Words", "words", " Hello,  world! ", []string{"Hello,", "world!"}, "Hello, world!"},
+		{"Punctuation", "punctuation", `Don't stop (at 3.14), "ok"?`,
+			[]string{"Don't", "stop", "(", "at", "3.14", ")", ",", `"`, "ok", `"`, "?"}, `Don't stop (at 3.14), " ok "?`},
+		{"Whitespace", "whitespace", "Roses are red,\n\tviolets  (blue)\n\n", []string{"Roses", " ", "are", " ", "red", ",", "\n\t", "violets", "  ", "(", "blue", ")", "\n\n"},
+			"Roses are red,\n\tviolets  (blue)\n\n"},
+		{"Bytes", "bytes", "aé", []string{"a", "\xc3", "\xa9"}, "aé"},
+		{"Regex", `regex:[a-z]+|\d`, "ab12 c\nd", []string{"ab", "1", "2", "c", "d"}, "ab 1 2 c d"},
+		{"Regex spanning lines", `regex:a.*`, "xab\nac", []string{"ab", "ac"}, "ab ac"},
+		{"Regex empty matches", `regex:a*`, "baab", []string{"aa"}, "aa"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			model := New(Options{N: 1, Tokenizer: tt.tokenizer})
+			if got := model.Tokens(tt.text); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Tokens() = %q, want %q", got, tt.want)
+			}
+
+			// Scanners may only see part of a token at a time
+			scanner := bufio.NewScanner(iotest.OneByteReader(strings.NewReader(tt.text)))
+			scanner.Split(model.tokenizer.Split)
+			var got []string
+			for scanner.Scan() {
+				got = append(got, scanner.Text())
+			}
+			if err := scanner.Err(); err != nil || !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Split() one byte at a time = %q, %v, want %q", got, err, tt.want)
+			}
+
+			if got := model.split(model.join(tt.want)); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("split(join()) = %q, want %q", got, tt.want)
+			}
+			if got := model.detokenize("", tt.want); got != tt.detokens {
+				t.Errorf("detokenize() = %q, want %q", got, tt.detokens)
+			}
+		})
+	}
+}
+
+func TestParseTokenizer(t *testing.T) {
+	tests := []struct {
+		name    string
+		want    string
+		wantErr bool
+	}{
+		{"runes", "runes", false},
+		{"graphemes", "graphemes", false},
+		{"regex:a+", "regex:a+", false},
+		{"regex:(", "", true},
+		{"letters", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseTokenizer(tt.name)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseTokenizer() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && got.Name() != tt.want {
+				t.Errorf("ParseTokenizer().Name() = %q, want %q", got.Name(), tt.want)
+			}
+		})
+	}
+}
+
+func TestPunctuationDetokenizer(t *testing.T) {
+	tests := []struct {
+		prev, next string
+		want       string
+	}{
+		{"Hello", ",", ""},
+		{"world", "…", ""},
+		{"(", "at", ""},
+		{"«", "oui", ""},
+		{"Hello", "world", " "},
+		{"Hello", "", " "},
+		{"", "world", " "},
+		// Only single punctuation marks are joined
+		{"what", "!?", " "},
+		{"what", "?!", " "},
+		{"((", "at", " "},
+		// Stray bytes of a punctuation mark are not punctuation
+		{"and", "\x80", " "},
+		{"\xe2", "and", " "},
+	}
+	for _, tt := range tests {
+		if got := (punctuationDetokenizer{}).Separator(tt.prev, tt.next); got != tt.want {
+			t.Errorf("Separator(%q, %q) = %q, want %q", tt.prev, tt.next, got, tt.want)
+		}
+	}
+}
+
+func TestModelTokenizer(t *testing.T) {
+	model := New(Options{N: 1, Mode: TokenMode, Tokenizer: "punctuation"})
+	if err := model.Train(strings.NewReader("Hello, world!")); err != nil {
+		t.Fatalf("Train() error = %v", err)
+	}
+	want := StringHistogram{"Hello": {",": 1}, ",": {"world": 1}, "world": {"!": 1}}
+	if !reflect.DeepEqual(model.Histogram(), want) {
+		t.Errorf("Histogram() = %q, want %q", model.Histogram(), want)
+	}
+	if got := model.Generate("Hello", 10); got != "Hello, world!" {
+		t.Errorf("Generate() = %q, want %q", got, "Hello, world!")
+	}
+
+	poem := New(Options{N: 1, Mode: TokenMode, Tokenizer: "whitespace"})
+	if err := poem.Train(strings.NewReader("one\n\ttwo  three\n")); err != nil {
+		t.Fatalf("Train() error = %v", err)
+	}
+	if got := poem.Generate("one", 10); got != "one\n\ttwo  three\n" {
+		t.Errorf("Generate() = %q, want %q", got, "one\n\ttwo  three\n")
+	}
+
+	invalid := New(Options{N: 1, Tokenizer: "letters"})
+	if err := invalid.Train(strings.NewReader("Hello")); err == nil {
+		t.Errorf("Train() error = nil, want error for an unknown tokenizer")
+	}
+}
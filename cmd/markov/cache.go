@@ -0,0 +1,350 @@
+package main
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/brannondorsey/markov/chain"
+)
+
+// CacheOptions controls where, and whether, trained models are cached
+type CacheOptions struct {
+	// Dir is the directory to write caches to. Caches are written next to the
+	// corpus if Dir is empty.
+	Dir string
+	// Rebuild retrains the model even if an up to date cache exists.
+	Rebuild bool
+	// Disabled neither reads nor writes a cache.
+	Disabled bool
+}
+
+// GetCacheFilename returns the name of the file that caches the histogram built
+// from filenames with opts. The cache of a single corpus file is written next to
+// it unless cacheDir is set. Otherwise the cache is written to cacheDir, or the
+// user's cache directory, named after a hash of the corpus files' absolute paths
+// so that each distinct set of inputs gets its own cache.
+func GetCacheFilename(filenames []string, opts chain.Options, cacheDir string) (string, error) {
+	lowercaseString := ""
+	if opts.Lowercase {
+		lowercaseString = "lower"
+	}
+	wordsString := ""
+	if opts.Words {
+		wordsString = "words"
+	}
+	backoffString := ""
+	if opts.Backoff {
+		backoffString = "backoff"
+	}
+	modeString := ""
+	if opts.Mode == chain.TokenMode {
+		modeString = "token"
+	}
+	segmentString := ""
+	if opts.Segment != chain.SegmentNone {
+		segmentString = opts.Segment.String()
+	}
+	normalizeString := ""
+	if opts.Normalization != chain.NormalizeNone {
+		normalizeString = opts.Normalization.String()
+	}
+	if opts.CaseFold {
+		normalizeString += "fold"
+	}
+	if opts.StripAccents {
+		normalizeString += "noaccents"
+	}
+	if opts.StripControl {
+		normalizeString += "nocontrol"
+	}
+	// Regular expressions may not be valid in filenames, so they are hashed
+	tokenizerString := strings.Replace(opts.Tokenizer, ":", "", 1)
+	if strings.HasPrefix(opts.Tokenizer, "regex:") {
+		tokenizerString = fmt.Sprintf("regex%x", sha256.Sum256([]byte(opts.Tokenizer)))[:13]
+	}
+	if opts.Detokenizer != "" {
+		tokenizerString += "+" + opts.Detokenizer
+	}
+	suffix := fmt.Sprintf("cache.n%d%s%s%s%s%s%s%s.bin", opts.N, lowercaseString, normalizeString, wordsString, backoffString, modeString, segmentString, tokenizerString)
+	if cacheDir == "" && len(filenames) == 1 {
+		return fmt.Sprintf("%v.%s", filenames[0], suffix), nil
+	}
+	if cacheDir == "" {
+		userCacheDir, err := os.UserCacheDir()
+		if err != nil {
+			return "", err
+		}
+		cacheDir = filepath.Join(userCacheDir, "markov")
+	}
+	pathHash := sha256.New()
+	for _, filename := range filenames {
+		abs, err := filepath.Abs(filename)
+		if err != nil {
+			return "", err
+		}
+		fmt.Fprintln(pathHash, abs)
+	}
+	name := fmt.Sprintf("%v.%x.%s", filepath.Base(filenames[0]), pathHash.Sum(nil)[:4], suffix)
+	return filepath.Join(cacheDir, name), nil
+}
+
+// GetCorpusFingerprint identifies filenames by their absolute paths, sizes and
+// modification times, without reading them
+func GetCorpusFingerprint(filenames []string) (string, error) {
+	fingerprints := make([]string, len(filenames))
+	for i, filename := range filenames {
+		abs, err := filepath.Abs(filename)
+		if err != nil {
+			return "", err
+		}
+		info, err := os.Stat(abs)
+		if err != nil {
+			return "", err
+		}
+		fingerprints[i] = fmt.Sprintf("%s:%d:%d", abs, info.Size(), info.ModTime().UnixNano())
+	}
+	return strings.Join(fingerprints, "\n"), nil
+}
+
+// GetCorpusHash returns the corpus hash of the contents of filenames
+func GetCorpusHash(filenames []string) (string, error) {
+	inputs, err := openInputs(filenames)
+	if err != nil {
+		return "", err
+	}
+	defer closeInputs(inputs)
+	return chain.HashCorpus(inputs...)
+}
+
+// IsCacheValid reports whether a cache with header can be used in place of
+// training on filenames with opts. The cache must have been written by this
+// version of markov with the same options. The corpus is only hashed if its
+// fingerprint has changed since the cache was written.
+func IsCacheValid(header chain.Header, filenames []string, fingerprint string, opts chain.Options) (bool, error) {
+	if header.ToolVersion != chain.Version || header.Options != opts {
+		return false, nil
+	}
+	if header.Corpus.Fingerprint == fingerprint {
+		return true, nil
+	}
+	hash, err := GetCorpusHash(filenames)
+	if err != nil {
+		return false, err
+	}
+	return header.Corpus.Hash == hash, nil
+}
+
+// TrainOptions choose the resources a model is trained with, which don't change
+// the model itself.
+type TrainOptions struct {
+	// MemoryLimit is the most memory that counting the inputs may use in bytes,
+	// or 0 for no limit.
+	MemoryLimit int64
+	// Jobs is the number of goroutines that count the inputs.
+	Jobs int
+}
+
+// LoadOrCreateModel loads the model trained on filenames from its cache, training
+// and caching a new model with train if no up to date cache exists. Models
+// trained on standard input are never cached.
+func LoadOrCreateModel(filenames []string, opts chain.Options, cache CacheOptions, train TrainOptions) (*chain.Model, error) {
+	var fingerprint string
+	var err error
+	if isStream(filenames) {
+		// Streams can't be fingerprinted or read twice to check a cache
+		cache.Disabled = true
+	} else if fingerprint, err = GetCorpusFingerprint(filenames); err != nil {
+		return nil, err
+	}
+	var cacheFilename string
+	if !cache.Disabled {
+		cacheFilename, err = GetCacheFilename(filenames, opts, cache.Dir)
+		if err != nil {
+			return nil, err
+		}
+		if !cache.Rebuild {
+			model, err := loadCache(cacheFilename, filenames, fingerprint, opts)
+			if err != nil || model != nil {
+				return model, err
+			}
+		}
+	}
+
+	// Build histogram and save cache
+	model, err := trainModel(filenames, fingerprint, opts, train)
+	if err != nil {
+		return nil, err
+	}
+	if !cache.Disabled {
+		if err := os.MkdirAll(filepath.Dir(cacheFilename), 0755); err != nil {
+			return nil, err
+		}
+		if err := CacheModel(model, cacheFilename); err != nil {
+			return nil, err
+		}
+	}
+	return model, nil
+}
+
+// TrainModel trains a new model on filenames with opts and train. Unless one of
+// filenames is standard input, the model records the fingerprint of the corpus
+// so that it can be used as a cache.
+func TrainModel(filenames []string, opts chain.Options, train TrainOptions) (*chain.Model, error) {
+	var fingerprint string
+	if !isStream(filenames) {
+		var err error
+		if fingerprint, err = GetCorpusFingerprint(filenames); err != nil {
+			return nil, err
+		}
+	}
+	return trainModel(filenames, fingerprint, opts, train)
+}
+
+func trainModel(filenames []string, fingerprint string, opts chain.Options, train TrainOptions) (*chain.Model, error) {
+	inputs, err := openInputs(filenames)
+	if err != nil {
+		return nil, err
+	}
+	defer closeInputs(inputs)
+	model := chain.New(opts)
+	model.MemoryLimit = train.MemoryLimit
+	model.Jobs = train.Jobs
+	if err := model.Train(inputs...); err != nil {
+		return nil, err
+	}
+	model.Corpus.Fingerprint = fingerprint
+	return model, nil
+}
+
+// UpdateModel adds the transitions in filenames to the model saved in filename
+// and returns it. The model must have been trained with opts.
+func UpdateModel(filename string, filenames []string, opts chain.Options, train TrainOptions) (*chain.Model, error) {
+	model, err := LoadModel(filename)
+	if err != nil {
+		return nil, err
+	}
+	if differences := optionDifferences(model.Options, opts); len(differences) > 0 {
+		model.Close()
+		return nil, fmt.Errorf("%s was trained with %s", filename, strings.Join(differences, "; "))
+	}
+	inputs, err := openInputs(filenames)
+	if err != nil {
+		model.Close()
+		return nil, err
+	}
+	defer closeInputs(inputs)
+	model.MemoryLimit = train.MemoryLimit
+	model.Jobs = train.Jobs
+	if err := model.Update(inputs...); err != nil {
+		model.Close()
+		return nil, err
+	}
+	return model, nil
+}
+
+// optionDifferences describes each option that a model was trained with that
+// differs from opts
+func optionDifferences(trained, opts chain.Options) []string {
+	tokenizer := func(opts chain.Options) string {
+		if opts.Tokenizer != "" {
+			return opts.Tokenizer
+		}
+		return chain.GetTokenizerName(opts.Words)
+	}
+	var differences []string
+	differ := func(name string, trained, want interface{}) {
+		if trained != want {
+			differences = append(differences, fmt.Sprintf("%s %v, not %v", name, trained, want))
+		}
+	}
+	differ("n-gram length", trained.N, opts.N)
+	differ("mode", trained.Mode, opts.Mode)
+	differ("lowercase", trained.Lowercase, opts.Lowercase)
+	differ("tokenizer", tokenizer(trained), tokenizer(opts))
+	differ("detokenizer", fmt.Sprintf("%q", trained.Detokenizer), fmt.Sprintf("%q", opts.Detokenizer))
+	differ("backoff", trained.Backoff, opts.Backoff)
+	differ("segment", trained.Segment, opts.Segment)
+	differ("normalization", trained.Normalization, opts.Normalization)
+	differ("case fold", trained.CaseFold, opts.CaseFold)
+	differ("strip accents", trained.StripAccents, opts.StripAccents)
+	differ("strip control", trained.StripControl, opts.StripControl)
+	return differences
+}
+
+// ReadModelHeader reads the header of the model saved in filename
+func ReadModelHeader(filename string) (chain.Header, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return chain.Header{}, err
+	}
+	defer file.Close()
+	return chain.ReadHeader(file)
+}
+
+// loadCache returns the model cached in cacheFilename, or nil if the cache
+// doesn't exist or is out of date
+func loadCache(cacheFilename string, filenames []string, fingerprint string, opts chain.Options) (*chain.Model, error) {
+	cacheFile, err := os.Open(cacheFilename)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer cacheFile.Close()
+	header, err := chain.ReadHeader(cacheFile)
+	if err != nil {
+		// Caches written in an unknown or older format are rebuilt
+		return nil, nil
+	}
+	if valid, err := IsCacheValid(header, filenames, fingerprint, opts); err != nil || !valid {
+		return nil, err
+	}
+	if _, err := cacheFile.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	model := chain.New(opts)
+	if err := model.Load(cacheFile); err != nil {
+		return nil, nil
+	}
+	return model, nil
+}
+
+// CacheModel saves model to filename. The model is written to a temporary file
+// first so that an interrupted write never leaves a truncated cache behind.
+func CacheModel(model *chain.Model, filename string) error {
+	return writeModel(filename, model.Save)
+}
+
+// SaveMappedModel saves model to filename in the memory-mapped layout, like
+// CacheModel.
+func SaveMappedModel(model *chain.Model, filename string) error {
+	return writeModel(filename, model.SaveMapped)
+}
+
+// writeModel writes a model to filename with save, through a temporary file
+func writeModel(filename string, save func(io.Writer) error) error {
+	file, err := ioutil.TempFile(filepath.Dir(filename), filepath.Base(filename)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(file.Name())
+	if err := save(file); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	return os.Rename(file.Name(), filename)
+}
+
+// LoadModel reads the model saved in filename by CacheModel, either as a cache
+// or by the train command, or memory-maps it if it was saved by SaveMappedModel.
+func LoadModel(filename string) (*chain.Model, error) {
+	return chain.OpenModel(filename)
+}
@@ -0,0 +1,341 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/brannondorsey/markov/chain"
+)
+
+func TestGetCacheFilename(t *testing.T) {
+	tests := []struct {
+		name     string
+		filename string
+		opts     chain.Options
+		want     string
+	}{
+		{"Characters", "corpus.txt", chain.Options{N: 3}, "corpus.txt.cache.n3.bin"},
+		{"Lowercase characters", "corpus.txt", chain.Options{N: 2, Lowercase: true}, "corpus.txt.cache.n2lower.bin"},
+		{"Words", "corpus.txt", chain.Options{N: 1, Words: true}, "corpus.txt.cache.n1words.bin"},
+		{"Lowercase words", "corpus.txt", chain.Options{N: 4, Lowercase: true, Words: true}, "corpus.txt.cache.n4lowerwords.bin"},
+		{"Backoff", "corpus.txt", chain.Options{N: 3, Backoff: true}, "corpus.txt.cache.n3backoff.bin"},
+		{"Token mode", "corpus.txt", chain.Options{N: 3, Mode: chain.TokenMode, Words: true}, "corpus.txt.cache.n3wordstoken.bin"},
+		{"Normalization", "corpus.txt", chain.Options{N: 2, Normalization: chain.NormalizeNFKC, CaseFold: true, StripAccents: true}, "corpus.txt.cache.n2nfkcfoldnoaccents.bin"},
+		{"Segment", "corpus.txt", chain.Options{N: 2, Segment: chain.SegmentSentences}, "corpus.txt.cache.n2sentences.bin"},
+		{"Tokenizer", "corpus.txt", chain.Options{N: 2, Tokenizer: "graphemes", Detokenizer: "space"}, "corpus.txt.cache.n2graphemes+space.bin"},
+		{"BPE tokenizer", "corpus.txt", chain.Options{N: 2, Tokenizer: "bpe:500"}, "corpus.txt.cache.n2bpe500.bin"},
+		{"Regex tokenizer", "corpus.txt", chain.Options{N: 2, Tokenizer: `regex:\w+/\d`}, "corpus.txt.cache.n2regex1cd35673.bin"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := GetCacheFilename([]string{tt.filename}, tt.opts, "")
+			if err != nil {
+				t.Fatalf("GetCacheFilename() error = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("GetCacheFilename() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	a := filepath.Join("a", "corpus.txt")
+	b := filepath.Join("b", "corpus.txt")
+	cached := make(map[string]bool)
+	for _, filenames := range [][]string{{a}, {b}, {a, b}, {b, a}} {
+		got, err := GetCacheFilename(filenames, chain.Options{N: 3}, "cache")
+		if err != nil {
+			t.Fatalf("GetCacheFilename() error = %v", err)
+		}
+		if filepath.Dir(got) != "cache" || cached[got] {
+			t.Errorf("GetCacheFilename(%v) = %v, want a distinct file in cache", filenames, got)
+		}
+		cached[got] = true
+	}
+}
+
+func writeCorpus(t *testing.T, filename string, text string, modTime time.Time) {
+	if err := ioutil.WriteFile(filename, []byte(text), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(filename, modTime, modTime); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLoadOrCreateModel(t *testing.T) {
+	dir, err := ioutil.TempDir("", "markov")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	filename := filepath.Join(dir, "corpus.txt")
+	modTime := time.Unix(1500000000, 0)
+	writeCorpus(t, filename, "Hello world! This is a text string to be used during testing. Its short.", modTime)
+	opts := chain.Options{N: 2, Lowercase: true}
+	cacheFilename, _ := GetCacheFilename([]string{filename}, opts, "")
+
+	created, err := LoadOrCreateModel([]string{filename}, opts, CacheOptions{}, TrainOptions{})
+	if err != nil {
+		t.Fatalf("LoadOrCreateModel() error = %v", err)
+	}
+	if _, err := os.Stat(cacheFilename); err != nil {
+		t.Fatalf("LoadOrCreateModel() did not write a cache: %v", err)
+	}
+	loaded, err := LoadOrCreateModel([]string{filename}, opts, CacheOptions{}, TrainOptions{})
+	if err != nil {
+		t.Fatalf("LoadOrCreateModel() error = %v", err)
+	}
+	if !reflect.DeepEqual(created.Histogram(), loaded.Histogram()) {
+		t.Errorf("LoadOrCreateModel() = %v, want %v", loaded.Histogram(), created.Histogram())
+	}
+
+	// Touching the corpus without changing it keeps the cache
+	writeCorpus(t, filename, "Hello world! This is a text string to be used during testing. Its short.", modTime.Add(time.Hour))
+	touched, err := LoadOrCreateModel([]string{filename}, opts, CacheOptions{}, TrainOptions{})
+	if err != nil {
+		t.Fatalf("LoadOrCreateModel() error = %v", err)
+	}
+	if touched.Corpus != created.Corpus {
+		t.Errorf("LoadOrCreateModel() corpus = %+v, want cached %+v", touched.Corpus, created.Corpus)
+	}
+
+	// Editing the corpus rebuilds the cache
+	writeCorpus(t, filename, "Goodbye world! This is a text string to be used during testing. Its long.", modTime.Add(2*time.Hour))
+	edited, err := LoadOrCreateModel([]string{filename}, opts, CacheOptions{}, TrainOptions{})
+	if err != nil {
+		t.Fatalf("LoadOrCreateModel() error = %v", err)
+	}
+	if _, ok := edited.Histogram()["go"]; !ok || edited.Corpus.Hash == created.Corpus.Hash {
+		t.Errorf("LoadOrCreateModel() = %v, want histogram of the edited corpus", edited.Histogram())
+	}
+
+	// Different options never share a cache
+	words, err := LoadOrCreateModel([]string{filename}, chain.Options{N: 2, Lowercase: true, Words: true}, CacheOptions{}, TrainOptions{})
+	if err != nil {
+		t.Fatalf("LoadOrCreateModel() error = %v", err)
+	}
+	if _, ok := words.Histogram()["goodbye world!"]; !ok {
+		t.Errorf("LoadOrCreateModel() = %v, want word histogram", words.Histogram())
+	}
+
+	if _, err := LoadOrCreateModel([]string{filepath.Join(dir, "missing.txt")}, opts, CacheOptions{}, TrainOptions{}); err == nil {
+		t.Errorf("LoadOrCreateModel() error = nil, want error for missing corpus")
+	}
+}
+
+func TestLoadOrCreateModelCacheOptions(t *testing.T) {
+	dir, err := ioutil.TempDir("", "markov")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	filename := filepath.Join(dir, "corpus.txt")
+	writeCorpus(t, filename, "Hello world!", time.Unix(1500000000, 0))
+	opts := chain.Options{N: 1}
+	cacheFilename, _ := GetCacheFilename([]string{filename}, opts, "")
+
+	if _, err := LoadOrCreateModel([]string{filename}, opts, CacheOptions{Disabled: true}, TrainOptions{}); err != nil {
+		t.Fatalf("LoadOrCreateModel() error = %v", err)
+	}
+	if _, err := os.Stat(cacheFilename); !os.IsNotExist(err) {
+		t.Errorf("LoadOrCreateModel() wrote a cache with caching disabled")
+	}
+
+	cacheDir := filepath.Join(dir, "cache")
+	if _, err := LoadOrCreateModel([]string{filename}, opts, CacheOptions{Dir: cacheDir}, TrainOptions{}); err != nil {
+		t.Fatalf("LoadOrCreateModel() error = %v", err)
+	}
+	dirCacheFilename, _ := GetCacheFilename([]string{filename}, opts, cacheDir)
+	if _, err := os.Stat(dirCacheFilename); err != nil {
+		t.Errorf("LoadOrCreateModel() did not write a cache to --cache-dir: %v", err)
+	}
+
+	// A corrupt cache is rebuilt when requested, and also when it can't be read
+	if err := ioutil.WriteFile(dirCacheFilename, []byte("garbage"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	for _, cache := range []CacheOptions{{Dir: cacheDir}, {Dir: cacheDir, Rebuild: true}} {
+		model, err := LoadOrCreateModel([]string{filename}, opts, cache, TrainOptions{})
+		if err != nil {
+			t.Fatalf("LoadOrCreateModel() error = %v", err)
+		}
+		if len(model.Histogram()) == 0 {
+			t.Errorf("LoadOrCreateModel() returned an empty model")
+		}
+	}
+}
+
+func TestLoadOrCreateModelMultipleInputs(t *testing.T) {
+	dir, err := ioutil.TempDir("", "markov")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	first := filepath.Join(dir, "first.txt")
+	second := filepath.Join(dir, "second.txt")
+	modTime := time.Unix(1500000000, 0)
+	writeCorpus(t, first, "abcde", modTime)
+	writeCorpus(t, second, "vwxyz", modTime)
+	opts := chain.Options{N: 1}
+	cache := CacheOptions{Dir: filepath.Join(dir, "cache")}
+
+	model, err := LoadOrCreateModel([]string{first, second}, opts, cache, TrainOptions{})
+	if err != nil {
+		t.Fatalf("LoadOrCreateModel() error = %v", err)
+	}
+	want := chain.StringHistogram{
+		"a": {"b": 1}, "b": {"c": 1}, "c": {"d": 1},
+		"v": {"w": 1}, "w": {"x": 1}, "x": {"y": 1},
+	}
+	if !reflect.DeepEqual(model.Histogram(), want) {
+		t.Errorf("LoadOrCreateModel() = %v, want %v", model.Histogram(), want)
+	}
+
+	// Changing any one input rebuilds the combined cache
+	writeCorpus(t, second, "vwxZz", modTime.Add(time.Hour))
+	model, err = LoadOrCreateModel([]string{first, second}, opts, cache, TrainOptions{})
+	if err != nil {
+		t.Fatalf("LoadOrCreateModel() error = %v", err)
+	}
+	if got := model.Histogram()["x"]; !reflect.DeepEqual(got, map[string]uint32{"Z": 1}) {
+		t.Errorf("LoadOrCreateModel() = %v, want rebuilt histogram", model.Histogram())
+	}
+}
+
+func TestLoadOrCreateModelStdin(t *testing.T) {
+	dir, err := ioutil.TempDir("", "markov")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	stdinFilename := filepath.Join(dir, "stdin.txt")
+	writeCorpus(t, stdinFilename, "abcde", time.Unix(1500000000, 0))
+	stdin, err := os.Open(stdinFilename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stdin.Close()
+	defer func(original *os.File) { os.Stdin = original }(os.Stdin)
+	os.Stdin = stdin
+
+	cacheDir := filepath.Join(dir, "cache")
+	model, err := LoadOrCreateModel([]string{StdinFilename}, chain.Options{N: 1}, CacheOptions{Dir: cacheDir}, TrainOptions{})
+	if err != nil {
+		t.Fatalf("LoadOrCreateModel() error = %v", err)
+	}
+	want := chain.StringHistogram{"a": {"b": 1}, "b": {"c": 1}, "c": {"d": 1}}
+	if !reflect.DeepEqual(model.Histogram(), want) {
+		t.Errorf("LoadOrCreateModel() = %v, want %v", model.Histogram(), want)
+	}
+	if _, err := os.Stat(cacheDir); !os.IsNotExist(err) {
+		t.Errorf("LoadOrCreateModel() cached a model trained on standard input")
+	}
+}
+
+func TestTrainModel(t *testing.T) {
+	dir, err := ioutil.TempDir("", "markov")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	corpus := filepath.Join(dir, "corpus.txt")
+	modelFilename := filepath.Join(dir, "model.bin")
+	writeCorpus(t, corpus, "Hello world! This is a text string to be used during testing. Its short.", time.Unix(1500000000, 0))
+	opts := chain.Options{N: 2, Mode: chain.TokenMode, Backoff: true}
+	model, err := TrainModel([]string{corpus}, opts, TrainOptions{})
+	if err != nil {
+		t.Fatalf("TrainModel() error = %v", err)
+	}
+	fingerprint, _ := GetCorpusFingerprint([]string{corpus})
+	if model.Options != opts || model.Corpus.Fingerprint != fingerprint || model.Corpus.Hash == "" {
+		t.Errorf("TrainModel() = %+v %+v, want options %+v and corpus fingerprint %q", model.Options, model.Corpus, opts, fingerprint)
+	}
+	if err := CacheModel(model, modelFilename); err != nil {
+		t.Fatalf("CacheModel() error = %v", err)
+	}
+	// Counting on several goroutines trains the same model
+	parallel, err := TrainModel([]string{corpus}, opts, TrainOptions{Jobs: 4})
+	if err != nil {
+		t.Fatalf("TrainModel() with 4 jobs error = %v", err)
+	}
+	if !reflect.DeepEqual(parallel.Histogram(), model.Histogram()) || parallel.Corpus != model.Corpus {
+		t.Errorf("TrainModel() with 4 jobs = %v, want %v", parallel.Histogram(), model.Histogram())
+	}
+
+	// The saved model generates the same text without the corpus
+	if err := os.Remove(corpus); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := LoadModel(modelFilename)
+	if err != nil {
+		t.Fatalf("LoadModel() error = %v", err)
+	}
+	generate := generateOptions{Prompt: "Th", Max: 40, Seed: 7, DeadEnd: chain.Backoff}
+	if got, want := generate.Generate(loaded), generate.Generate(model); got != want {
+		t.Errorf("Generate() from the saved model = %q, want %q", got, want)
+	}
+
+	if _, err := TrainModel([]string{corpus}, opts, TrainOptions{}); err == nil {
+		t.Errorf("TrainModel() error = nil, want error for missing corpus")
+	}
+}
+
+func TestUpdateModel(t *testing.T) {
+	dir, err := ioutil.TempDir("", "markov")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	old := filepath.Join(dir, "old.txt")
+	text := filepath.Join(dir, "new.txt")
+	writeCorpus(t, old, "Hello world! This is a text string to be used during testing.", time.Unix(1500000000, 0))
+	writeCorpus(t, text, "Hello again, world. Its short.", time.Unix(1500000000, 0))
+	opts := chain.Options{N: 2, Words: true, Backoff: true}
+	want, err := TrainModel([]string{old, text}, opts, TrainOptions{})
+	if err != nil {
+		t.Fatalf("TrainModel() error = %v", err)
+	}
+
+	for _, save := range []func(*chain.Model, string) error{CacheModel, SaveMappedModel} {
+		model, err := TrainModel([]string{old}, opts, TrainOptions{})
+		if err != nil {
+			t.Fatalf("TrainModel() error = %v", err)
+		}
+		modelFilename := filepath.Join(dir, "model.bin")
+		if err := save(model, modelFilename); err != nil {
+			t.Fatalf("saving the model error = %v", err)
+		}
+		updated, err := UpdateModel(modelFilename, []string{text}, opts, TrainOptions{Jobs: 2})
+		if err != nil {
+			t.Fatalf("UpdateModel() error = %v", err)
+		}
+		if !reflect.DeepEqual(updated.Histogram(), want.Histogram()) {
+			t.Errorf("UpdateModel() = %v, want %v", updated.Histogram(), want.Histogram())
+		}
+		updated.Close()
+
+		mismatched := opts
+		mismatched.N, mismatched.Lowercase = 3, true
+		_, err = UpdateModel(modelFilename, []string{text}, mismatched, TrainOptions{})
+		if want := "n-gram length 2, not 3; lowercase false, not true"; err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("UpdateModel() error = %v, want it to contain %q", err, want)
+		}
+		if _, err := UpdateModel(modelFilename, []string{filepath.Join(dir, "missing.txt")}, opts, TrainOptions{}); err == nil {
+			t.Errorf("UpdateModel() error = nil, want error for missing input")
+		}
+	}
+	if _, err := UpdateModel(filepath.Join(dir, "missing.bin"), []string{text}, opts, TrainOptions{}); err == nil {
+		t.Errorf("UpdateModel() error = nil, want error for missing model")
+	}
+}
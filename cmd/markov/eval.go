@@ -0,0 +1,69 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/brannondorsey/markov/chain"
+	flag "github.com/spf13/pflag"
+)
+
+// EvaluateModel scores how well the model saved in modelFilename predicts the
+// held-out text in filenames, using the probabilities estimated by estimator.
+func EvaluateModel(modelFilename string, filenames []string, estimator chain.Estimator) (chain.Evaluation, error) {
+	model, err := LoadModel(modelFilename)
+	if err != nil {
+		return chain.Evaluation{}, err
+	}
+	inputs, err := openInputs(filenames)
+	if err != nil {
+		return chain.Evaluation{}, err
+	}
+	defer closeInputs(inputs)
+	return chain.Evaluate(model, estimator, inputs...)
+}
+
+// PrintEvaluation writes a human-readable report of eval to w
+func PrintEvaluation(w io.Writer, eval chain.Evaluation) {
+	fmt.Fprintf(w, "predictions:          %d\n", eval.Predictions)
+	fmt.Fprintf(w, "scored tokens:        %d\n", eval.Tokens)
+	fmt.Fprintf(w, "log-prob per token:   %.4f\n", eval.LogProbPerToken())
+	fmt.Fprintf(w, "cross-entropy:        %.4f bits per token\n", eval.CrossEntropy())
+	fmt.Fprintf(w, "perplexity:           %.4f\n", eval.Perplexity())
+	fmt.Fprintf(w, "oov rate:             %.2f%% (%d)\n", eval.OOVRate()*100, eval.OOV)
+	fmt.Fprintf(w, "unseen context rate:  %.2f%% (%d)\n", eval.UnseenContextRate()*100, eval.UnseenContexts)
+}
+
+// runEval implements the eval subcommand
+func runEval(args []string) {
+	flags := flag.NewFlagSet("eval", flag.ExitOnError)
+	modelFilename := flags.String("model", "", "The model file to evaluate, written by the train command or cached by a previous run.")
+	help := flags.BoolP("help", "h", false, "Show this screen.")
+	smoothing := addSmoothingFlags(flags, "kneser-ney")
+	include := flags.StringSlice("include", nil, "Only read files whose names match this pattern when walking input directories. May be\nrepeated.")
+	exclude := flags.StringSlice("exclude", nil, "Skip files whose names match this pattern when walking input directories. May be\nrepeated.")
+
+	flags.Usage = func() {
+		fmt.Printf("Usage: %s eval [OPTIONS] --model <model> <input> [<input> ...]\n", os.Args[0])
+		fmt.Println("Scores how well a model predicts held-out text. Inputs are read like training inputs.")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+	if flags.NArg() < 1 || *modelFilename == "" || *help {
+		flags.Usage()
+		os.Exit(1)
+	}
+	estimator := smoothing.estimator()
+	inputFilenames, err := ResolveInputs(flags.Args(), InputOptions{Include: *include, Exclude: *exclude})
+	if err != nil {
+		fmt.Printf("[ERROR] %v.\n", err)
+		os.Exit(1)
+	}
+	eval, err := EvaluateModel(*modelFilename, inputFilenames, estimator)
+	if err != nil {
+		fmt.Printf("[ERROR] Failed to evaluate %s: %v.\n", *modelFilename, err)
+		os.Exit(1)
+	}
+	PrintEvaluation(os.Stdout, eval)
+}
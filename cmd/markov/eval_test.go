@@ -0,0 +1,63 @@
+package main
+
+import (
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/brannondorsey/markov/chain"
+)
+
+func TestEvaluateModel(t *testing.T) {
+	dir, err := ioutil.TempDir("", "markov")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	corpus := filepath.Join(dir, "corpus.txt")
+	heldOut := filepath.Join(dir, "held-out.txt")
+	writeCorpus(t, corpus, "the cat sat on the mat", time.Unix(1500000000, 0))
+	writeCorpus(t, heldOut, "the dog sat on the mat", time.Unix(1500000000, 0))
+	model, err := LoadOrCreateModel([]string{corpus}, chain.Options{N: 2, Mode: chain.TokenMode}, CacheOptions{}, TrainOptions{})
+	if err != nil {
+		t.Fatalf("LoadOrCreateModel() error = %v", err)
+	}
+	modelFilename, _ := GetCacheFilename([]string{corpus}, model.Options, "")
+
+	tests := []struct {
+		name      string
+		estimator chain.Estimator
+		finite    bool
+	}{
+		{"MLE", chain.Estimator{}, false},
+		{"Kneser-Ney", chain.Estimator{Smoothing: chain.KneserNey}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			eval, err := EvaluateModel(modelFilename, []string{heldOut}, tt.estimator)
+			if err != nil {
+				t.Fatalf("EvaluateModel() error = %v", err)
+			}
+			if eval.Predictions != len("the dog sat on the mat")-2 || eval.OOV != 2 {
+				t.Errorf("EvaluateModel() = %+v, want 20 predictions and 2 OOV for \"d\" and \"g\"", eval)
+			}
+			if finite := !math.IsInf(eval.Perplexity(), 0); finite != tt.finite {
+				t.Errorf("EvaluateModel() perplexity = %v, want finite %v", eval.Perplexity(), tt.finite)
+			}
+			var report strings.Builder
+			PrintEvaluation(&report, eval)
+			if !strings.Contains(report.String(), "perplexity:") {
+				t.Errorf("PrintEvaluation() = %q, want perplexity", report.String())
+			}
+		})
+	}
+
+	if _, err := EvaluateModel(filepath.Join(dir, "missing.bin"), []string{heldOut}, chain.Estimator{}); err == nil {
+		t.Errorf("EvaluateModel() error = nil, want error for missing model")
+	}
+}
@@ -0,0 +1,347 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/brannondorsey/markov/chain"
+	flag "github.com/spf13/pflag"
+)
+
+// trainFlags are the flags that choose how a model is trained and which files
+// it is trained on
+type trainFlags struct {
+	n            *int
+	mode         *string
+	lowercase    *bool
+	normalize    *string
+	caseFold     *bool
+	stripAccents *bool
+	stripControl *bool
+	words        *bool
+	tokenizer    *string
+	detokenizer  *string
+	backoff      *bool
+	segment      *string
+	memoryLimit  *string
+	jobs         *int
+	include      *[]string
+	exclude      *[]string
+}
+
+func addTrainFlags(flags *flag.FlagSet) *trainFlags {
+	return &trainFlags{
+		n:            flags.IntP("n-gram-length", "n", 3, "The number of characters to use for each n-gram."),
+		mode:         flags.String("mode", "ngram", "How to step from one n-gram to the next: \"ngram\" predicts the next n tokens from the\nlast n tokens, \"token\" predicts the single next token from the last n tokens."),
+		lowercase:    flags.BoolP("lowercase", "l", false, "Convert text to lowercase. Lowers the complexity of the sampling task, and may produce\nbetter results depending on the corpus."),
+		normalize:    flags.String("normalize", "none", "The Unicode normalization form to convert text and prompts to before they are split into\ntokens: \"nfc\" composes characters and their accents, \"nfkc\" also replaces compatibility\ncharacters like ligatures with their plain equivalents, \"none\" leaves text as it is."),
+		caseFold:     flags.Bool("case-fold", false, "Fold the case of text and prompts with full Unicode case folding, so that e.g. \"Straße\"\nand \"STRASSE\" are the same tokens."),
+		stripAccents: flags.Bool("strip-accents", false, "Remove accents and other combining marks from text and prompts."),
+		stripControl: flags.Bool("strip-control", false, "Remove control characters other than whitespace from text and prompts."),
+		words:        flags.BoolP("words", "w", false, "Use word-level n-grams instead of character-level n-grams. Short for --tokenizer words."),
+		tokenizer:    flags.String("tokenizer", "", "How to split the text into tokens: \"runes\", \"graphemes\" (user-perceived characters),\n\"words\", \"punctuation\" (words and punctuation marks), \"whitespace\" (words, punctuation marks\nand the whitespace between them, which keeps line breaks and indentation), \"bytes\",\n\"regex:<pattern>\" for each match of a regular expression, or \"bpe:<merges>\" for subwords\nlearned from the inputs by that many byte-pair merges. Defaults to \"runes\", or \"words\" with\n--words."),
+		detokenizer:  flags.String("detokenizer", "", "How to join generated tokens back into text: \"concat\", \"space\" or \"punctuation\".\nDefaults to the one paired with --tokenizer."),
+		backoff:      flags.Bool("backoff", false, "Also count the transitions from every shorter context, which --dead-end backoff and\nevery --smoothing but \"mle\" and \"add-k\" use. Takes more time and memory."),
+		segment:      flags.String("segment", "none", "Learn where segments of the text start and end: \"sentences\", \"lines\" or \"documents\".\nGenerated text then starts like a segment and stops at the end of --segments of them.\n\"none\" reads each document as one stream of text."),
+		memoryLimit:  flags.String("memory-limit", "4GB", "The most memory that counting the inputs may use, e.g. \"512MB\" or \"16GB\", or 0 for no\nlimit. Longer n-grams, --backoff and larger inputs all take more memory."),
+		jobs:         flags.Int("jobs", 1, "The number of goroutines that count the inputs. 0 uses every CPU. The model is the same\nfor any number of jobs, but each job takes memory for the part of the inputs it counts."),
+		include:      flags.StringSlice("include", nil, "Only read files whose names match this pattern when walking input directories. May be\nrepeated."),
+		exclude:      flags.StringSlice("exclude", nil, "Skip files whose names match this pattern when walking input directories. May be\nrepeated."),
+	}
+}
+
+// options returns the training options chosen by f, or exits if any are invalid
+func (f *trainFlags) options() chain.Options {
+	if *f.n < 1 {
+		fmt.Printf("[ERROR] The value of --n-gram-length must be at least 1. Received %d.\n", *f.n)
+		os.Exit(1)
+	}
+	mode, err := chain.ParseMode(*f.mode)
+	if err != nil {
+		fmt.Printf("[ERROR] The value of --mode is invalid: %v.\n", err)
+		os.Exit(1)
+	}
+	segment, err := chain.ParseSegment(*f.segment)
+	if err != nil {
+		fmt.Printf("[ERROR] The value of --segment is invalid: %v.\n", err)
+		os.Exit(1)
+	}
+	normalization, err := chain.ParseNormalization(*f.normalize)
+	if err != nil {
+		fmt.Printf("[ERROR] The value of --normalize is invalid: %v.\n", err)
+		os.Exit(1)
+	}
+	opts := chain.Options{N: *f.n, Mode: mode, Lowercase: *f.lowercase, Words: *f.words, Backoff: *f.backoff, Segment: segment}
+	opts.Normalization = normalization
+	opts.CaseFold = *f.caseFold
+	opts.StripAccents = *f.stripAccents
+	opts.StripControl = *f.stripControl
+	// Options only name the tokenizers that aren't chosen by default, like the
+	// headers of model files
+	switch tokenizer := *f.tokenizer; {
+	case *f.words && tokenizer != "" && tokenizer != "words":
+		fmt.Printf("[ERROR] --words can't be used with --tokenizer %s.\n", tokenizer)
+		os.Exit(1)
+	case tokenizer == "words":
+		opts.Words = true
+	case tokenizer != "runes":
+		opts.Tokenizer = tokenizer
+	}
+	tokenizer, err := chain.ParseTokenizer(chain.GetTokenizerName(opts.Words))
+	if opts.Tokenizer != "" {
+		tokenizer, err = chain.ParseTokenizer(opts.Tokenizer)
+	}
+	if err != nil {
+		fmt.Printf("[ERROR] The value of --tokenizer is invalid: %v.\n", err)
+		os.Exit(1)
+	}
+	if *f.detokenizer != "" && *f.detokenizer != tokenizer.Detokenizer() {
+		if _, err := chain.ParseDetokenizer(*f.detokenizer); err != nil {
+			fmt.Printf("[ERROR] The value of --detokenizer is invalid: %v.\n", err)
+			os.Exit(1)
+		}
+		opts.Detokenizer = *f.detokenizer
+	}
+	return opts
+}
+
+// inherit sets each flag of f that wasn't given in flags to the option that a
+// model was trained with, so that the model can be updated without repeating
+// the flags it was trained with
+func (f *trainFlags) inherit(flags *flag.FlagSet, opts chain.Options) {
+	inherited := func(name string) bool { return !flags.Changed(name) }
+	if inherited("n-gram-length") {
+		*f.n = opts.N
+	}
+	if inherited("mode") {
+		*f.mode = opts.Mode.String()
+	}
+	if inherited("lowercase") {
+		*f.lowercase = opts.Lowercase
+	}
+	if inherited("normalize") {
+		*f.normalize = opts.Normalization.String()
+	}
+	if inherited("case-fold") {
+		*f.caseFold = opts.CaseFold
+	}
+	if inherited("strip-accents") {
+		*f.stripAccents = opts.StripAccents
+	}
+	if inherited("strip-control") {
+		*f.stripControl = opts.StripControl
+	}
+	// --words and --tokenizer both choose the tokenizer
+	if inherited("words") && inherited("tokenizer") {
+		*f.words, *f.tokenizer = opts.Words, opts.Tokenizer
+	}
+	if inherited("detokenizer") {
+		*f.detokenizer = opts.Detokenizer
+	}
+	if inherited("backoff") {
+		*f.backoff = opts.Backoff
+	}
+	if inherited("segment") {
+		*f.segment = opts.Segment.String()
+	}
+}
+
+// training returns the memory limit and jobs chosen by f, or exits if they are
+// invalid
+func (f *trainFlags) training() TrainOptions {
+	limit, err := parseByteSize(*f.memoryLimit)
+	if err != nil {
+		fmt.Printf("[ERROR] The value of --memory-limit is invalid: %v.\n", err)
+		os.Exit(1)
+	}
+	jobs := *f.jobs
+	if jobs == 0 {
+		jobs = runtime.NumCPU()
+	} else if jobs < 0 {
+		fmt.Printf("[ERROR] The value of --jobs must not be negative. Received %d.\n", jobs)
+		os.Exit(1)
+	}
+	return TrainOptions{MemoryLimit: limit, Jobs: jobs}
+}
+
+// parseByteSize parses a number of bytes with an optional unit, e.g. "512MB"
+// or "4GiB". Units are powers of 1024.
+func parseByteSize(s string) (int64, error) {
+	number := strings.TrimSuffix(strings.TrimSuffix(strings.ToUpper(strings.TrimSpace(s)), "B"), "I")
+	size := 1.0
+	for i, unit := range "KMGT" {
+		if strings.HasSuffix(number, string(unit)) {
+			number, size = strings.TrimSuffix(number, string(unit)), float64(int64(1)<<(10*(i+1)))
+			break
+		}
+	}
+	value, err := strconv.ParseFloat(strings.TrimSpace(number), 64)
+	if err != nil || value < 0 || math.IsNaN(value) || value*size > math.MaxInt64 {
+		return 0, fmt.Errorf("%q is not a size in bytes, like 512MB or 4GB", s)
+	}
+	return int64(value * size), nil
+}
+
+// inputs resolves args into the corpus files to read, or exits if they can't be
+func (f *trainFlags) inputs(args []string) []string {
+	filenames, err := ResolveInputs(args, InputOptions{Include: *f.include, Exclude: *f.exclude})
+	if err != nil {
+		fmt.Printf("[ERROR] %v.\n", err)
+		os.Exit(1)
+	}
+	return filenames
+}
+
+// smoothingFlags are the flags that choose a chain.Estimator
+type smoothingFlags struct {
+	smoothing *string
+	addK      *float64
+	discount  *float64
+}
+
+func addSmoothingFlags(flags *flag.FlagSet, smoothing string) *smoothingFlags {
+	return &smoothingFlags{
+		smoothing: flags.String("smoothing", smoothing, "How to estimate the probability of next n-grams: \"mle\" uses only the transitions\nseen in the dataset, while \"add-k\" (or \"laplace\"), \"witten-bell\",\n\"absolute-discounting\" and \"kneser-ney\" give every n-gram a chance to follow any\nother. All but \"mle\" and \"add-k\" work best with models trained with --backoff."),
+		addK:      flags.Float64("add-k", 1, "The count added to every transition by --smoothing add-k."),
+		discount:  flags.Float64("discount", 0.75, "The count subtracted from every transition by --smoothing absolute-discounting and\nkneser-ney. Must be between 0 and 1."),
+	}
+}
+
+// estimator returns the Estimator chosen by f, or exits if it is invalid
+func (f *smoothingFlags) estimator() chain.Estimator {
+	smoothing, err := chain.ParseSmoothing(*f.smoothing)
+	if err != nil {
+		fmt.Printf("[ERROR] The value of --smoothing is invalid: %v.\n", err)
+		os.Exit(1)
+	}
+	estimator := chain.Estimator{Smoothing: smoothing, K: *f.addK, Discount: *f.discount}
+	if err := estimator.Validate(); err != nil {
+		fmt.Printf("[ERROR] Invalid smoothing options: %v.\n", err)
+		os.Exit(1)
+	}
+	return estimator
+}
+
+// generateFlags are the flags that control how text is sampled from a model
+type generateFlags struct {
+	prompt      *string
+	max         *int
+	deadEnd     *string
+	temperature *float64
+	topK        *int
+	topP        *float64
+	greedy      *bool
+	seed        *int64
+	stream      *bool
+	count       *int
+	workers     *int
+	format      *string
+	delimiter   *string
+	segments    *int
+	smoothing   *smoothingFlags
+	flags       *flag.FlagSet
+}
+
+func addGenerateFlags(flags *flag.FlagSet) *generateFlags {
+	return &generateFlags{
+		prompt:      flags.StringP("prompt", "p", "", "The prompt to use."),
+		max:         flags.IntP("max", "m", 1000, "The maximum number of tokens (characters, or words with --words) to generate, in every\n--mode. Fewer tokens may be generated if the sequence encounters an n-gram that has no\nnext n-grams in the dataset."),
+		deadEnd:     flags.String("dead-end", "stop", "What to do when the sequence encounters an n-gram that has no next n-grams in the\ndataset: \"stop\" generating, \"backoff\" to the longest shorter context that has\nnext n-grams, or \"restart\" from a random n-gram. \"backoff\" needs a model trained\nwith --backoff."),
+		temperature: flags.Float64P("temperature", "t", 1, "Reshape the distribution of next n-grams before sampling. Values below 1 favour\nfrequent n-grams and produce more coherent text, values above 1 produce more creative\ntext."),
+		topK:        flags.Int("top-k", 0, "Only sample from the k most frequent next n-grams. 0 means no limit."),
+		topP:        flags.Float64("top-p", 1, "Only sample from the most frequent next n-grams whose probabilities add up to at least\np (nucleus sampling). 1 means no limit."),
+		greedy:      flags.Bool("greedy", false, "Always choose the most frequent next n-gram instead of sampling."),
+		smoothing:   addSmoothingFlags(flags, "mle"),
+		seed:        flags.Int64P("seed", "s", 0, "The random seed to use. The same model, options, and seed always generate the same\ntext. A random seed is used if not provided."),
+		stream:      flags.Bool("stream", false, "Write each n-gram as soon as it is generated instead of all the text at the end."),
+		count:       flags.IntP("count", "c", 1, "The number of independent samples to generate."),
+		workers:     flags.Int("workers", 1, "The number of samples to generate at the same time. 0 uses every CPU. The samples are\nthe same, and in the same order, for any number of workers."),
+		format:      flags.String("format", "text", "How to write the samples: \"text\" writes each followed by --delimiter, \"jsonl\" writes\neach as a JSON object with its index, seed and text on its own line."),
+		delimiter:   flags.String("delimiter", `\n`, "The text written after each sample with --format text. Escapes such as \\n and \\t are\ninterpreted."),
+		segments:    flags.Int("segments", 1, "The number of sentences, lines or documents to generate from a model trained with\n--segment before stopping."),
+		flags:       flags,
+	}
+}
+
+// generateOptions are the validated values of generateFlags
+type generateOptions struct {
+	Prompt    string
+	Max       int
+	Seed      int64
+	DeadEnd   chain.DeadEnd
+	Sampling  chain.Sampling
+	Estimator chain.Estimator
+	Stream    bool
+	Count     int
+	Workers   int
+	Format    string
+	Delimiter string
+	Segments  int
+}
+
+// options returns the generation options chosen by f, or exits if any are invalid
+func (f *generateFlags) options() generateOptions {
+	deadEnd, err := chain.ParseDeadEnd(*f.deadEnd)
+	if err != nil {
+		fmt.Printf("[ERROR] The value of --dead-end is invalid: %v.\n", err)
+		os.Exit(1)
+	}
+	sampling := chain.Sampling{Temperature: *f.temperature, TopK: *f.topK, TopP: *f.topP, Greedy: *f.greedy}
+	if err := sampling.Validate(); err != nil {
+		fmt.Printf("[ERROR] Invalid sampling options: %v.\n", err)
+		os.Exit(1)
+	}
+	seed := *f.seed
+	if !f.flags.Changed("seed") {
+		seed = time.Now().UTC().UnixNano() // always seed random!
+	}
+	if *f.count < 1 {
+		fmt.Printf("[ERROR] The value of --count must be at least 1. Received %d.\n", *f.count)
+		os.Exit(1)
+	}
+	workers := *f.workers
+	if workers == 0 {
+		workers = runtime.NumCPU()
+	} else if workers < 0 {
+		fmt.Printf("[ERROR] The value of --workers must not be negative. Received %d.\n", workers)
+		os.Exit(1)
+	}
+	if *f.format != "text" && *f.format != "jsonl" {
+		fmt.Printf("[ERROR] The value of --format must be \"text\" or \"jsonl\". Received \"%s\".\n", *f.format)
+		os.Exit(1)
+	}
+	// Streamed samples are written as they are generated, one at a time, so
+	// --workers 0 is refused even on a single CPU
+	if *f.stream && (*f.format != "text" || *f.workers != 1) {
+		fmt.Printf("[ERROR] --stream can only be used with --format text and --workers 1. Received --format %s and --workers %d.\n", *f.format, *f.workers)
+		os.Exit(1)
+	}
+	if *f.segments < 1 {
+		fmt.Printf("[ERROR] The value of --segments must be at least 1. Received %d.\n", *f.segments)
+		os.Exit(1)
+	}
+	delimiter, err := strconv.Unquote(`"` + strings.Replace(*f.delimiter, `"`, `\"`, -1) + `"`)
+	if err != nil {
+		fmt.Printf("[ERROR] The value of --delimiter is invalid: %v.\n", err)
+		os.Exit(1)
+	}
+	return generateOptions{
+		Prompt:    *f.prompt,
+		Max:       *f.max,
+		Seed:      seed,
+		DeadEnd:   deadEnd,
+		Sampling:  sampling,
+		Estimator: f.smoothing.estimator(),
+		Stream:    *f.stream,
+		Count:     *f.count,
+		Workers:   workers,
+		Format:    *f.format,
+		Delimiter: delimiter,
+		Segments:  *f.segments,
+	}
+}
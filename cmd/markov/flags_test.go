@@ -0,0 +1,33 @@
+package main
+
+import "testing"
+
+func TestParseByteSize(t *testing.T) {
+	tests := []struct {
+		size    string
+		want    int64
+		wantErr bool
+	}{
+		{"0", 0, false},
+		{"1024", 1024, false},
+		{"512MB", 512 << 20, false},
+		{"4GB", 4 << 30, false},
+		{"4GiB", 4 << 30, false},
+		{"1.5g", 3 << 29, false},
+		{"2 KB", 2 << 10, false},
+		{"-1GB", 0, true},
+		{"lots", 0, true},
+		{"NaN", 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.size, func(t *testing.T) {
+			got, err := parseByteSize(tt.size)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseByteSize() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("parseByteSize() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/brannondorsey/markov/chain"
+	flag "github.com/spf13/pflag"
+)
+
+// runGenerate implements the generate command
+func runGenerate(args []string) {
+	flags := flag.NewFlagSet("generate", flag.ExitOnError)
+	modelFilename := flags.String("model", "", "The model file to generate text from, written by the train command.")
+	generate := addGenerateFlags(flags)
+	help := flags.BoolP("help", "h", false, "Show this screen.")
+
+	flags.Usage = func() {
+		fmt.Printf("Usage: %s generate [OPTIONS] --model <model>\n", os.Args[0])
+		fmt.Println("Generates text from a model file. The corpus it was trained on is not needed.")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+	if flags.NArg() > 0 || *modelFilename == "" || *help {
+		flags.Usage()
+		os.Exit(1)
+	}
+	opts := generate.options()
+
+	model, err := LoadModel(*modelFilename)
+	if err != nil {
+		fmt.Printf("[ERROR] Failed to load the model %s: %v.\n", *modelFilename, err)
+		os.Exit(1)
+	}
+	if err := opts.Print(os.Stdout, model); err != nil {
+		fmt.Printf("[ERROR] Failed to write the generated text: %v.\n", err)
+		os.Exit(1)
+	}
+}
+
+// Generator returns a Generator for model configured by o
+func (o generateOptions) Generator(model *chain.Model) *chain.Generator {
+	generator := chain.NewGenerator(model, o.Seed)
+	generator.DeadEnd = o.DeadEnd
+	generator.Sampling = o.Sampling
+	generator.Estimator = o.Estimator
+	generator.Segments = o.Segments
+	return generator
+}
+
+// Generate continues the prompt with text sampled from model
+func (o generateOptions) Generate(model *chain.Model) string {
+	return o.Generator(model).Generate(o.Prompt, o.Max)
+}
+
+// SampleSeed returns the seed of the ith sample. The first sample uses Seed
+// itself, so any sample can be generated again on its own by passing its seed
+// with a count of 1.
+func (o generateOptions) SampleSeed(i int) int64 {
+	if i == 0 {
+		return o.Seed
+	}
+	// splitmix64, so that the seeds of neighbouring samples are unrelated
+	z := uint64(o.Seed) + uint64(i)*0x9e3779b97f4a7c15
+	z = (z ^ (z >> 30)) * 0xbf58476d1ce4e5b9
+	z = (z ^ (z >> 27)) * 0x94d049bb133111eb
+	return int64(z ^ (z >> 31))
+}
+
+// sample is one line of --format jsonl output
+type sample struct {
+	Index int    `json:"index"`
+	Seed  int64  `json:"seed"`
+	Text  string `json:"text"`
+}
+
+// Print writes Count samples from model to w in Format, generating Workers of
+// them at a time. If Stream is set each n-gram is written as soon as it is
+// generated.
+func (o generateOptions) Print(w io.Writer, model *chain.Model) error {
+	if o.Stream {
+		for i := 0; i < o.Count; i++ {
+			generator := o.Generator(model)
+			generator.Rand.Seed(o.SampleSeed(i))
+			err := generator.GenerateTo(o.Prompt, o.Max, func(piece string) error {
+				_, err := io.WriteString(w, piece)
+				return err
+			})
+			if err != nil {
+				return err
+			}
+			if _, err := io.WriteString(w, o.Delimiter); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	// Workers take samples in order, and each is written as soon as every
+	// sample before it has been
+	results := make([]chan string, o.Count)
+	for i := range results {
+		results[i] = make(chan string, 1)
+	}
+	jobs := make(chan int)
+	done := make(chan struct{})
+	defer close(done)
+	workers := o.Workers
+	if workers < 1 {
+		workers = 1
+	}
+	for worker := 0; worker < workers; worker++ {
+		go func() {
+			generator := o.Generator(model)
+			for i := range jobs {
+				generator.Rand.Seed(o.SampleSeed(i))
+				results[i] <- generator.Generate(o.Prompt, o.Max)
+			}
+		}()
+	}
+	go func() {
+		defer close(jobs)
+		for i := range results {
+			select {
+			case jobs <- i:
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	encoder := json.NewEncoder(w)
+	for i, result := range results {
+		text := <-result
+		var err error
+		if o.Format == "jsonl" {
+			err = encoder.Encode(sample{Index: i, Seed: o.SampleSeed(i), Text: text})
+		} else {
+			_, err = io.WriteString(w, text+o.Delimiter)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/brannondorsey/markov/chain"
+)
+
+// chunkWriter records each write separately
+type chunkWriter struct {
+	chunks []string
+}
+
+func (w *chunkWriter) Write(p []byte) (int, error) {
+	w.chunks = append(w.chunks, string(p))
+	return len(p), nil
+}
+
+func TestGenerateOptionsPrint(t *testing.T) {
+	model := chain.New(chain.Options{N: 2})
+	if err := model.Train(strings.NewReader("Hello world! This is a text string to be used during testing.")); err != nil {
+		t.Fatalf("Train() error = %v", err)
+	}
+	opts := generateOptions{Prompt: "Th", Max: 20, Seed: 9, Count: 1, Delimiter: "\n"}
+	want := opts.Generate(model) + "\n"
+
+	var out bytes.Buffer
+	if err := opts.Print(&out, model); err != nil {
+		t.Fatalf("Print() error = %v", err)
+	}
+	if out.String() != want {
+		t.Errorf("Print() = %q, want %q", out.String(), want)
+	}
+
+	opts.Stream = true
+	var streamed chunkWriter
+	if err := opts.Print(&streamed, model); err != nil {
+		t.Fatalf("Print() error = %v", err)
+	}
+	// The prompt, one write per 2-gram, and the newline
+	if got := strings.Join(streamed.chunks, ""); got != want || len(streamed.chunks) != 12 {
+		t.Errorf("Print() streamed %q, want %q in 12 writes", streamed.chunks, want)
+	}
+}
+
+func TestGenerateOptionsPrintCount(t *testing.T) {
+	model := chain.New(chain.Options{N: 1, Words: true, Mode: chain.TokenMode})
+	if err := model.Train(strings.NewReader("the cat sat on the mat and the dog sat on the cat")); err != nil {
+		t.Fatalf("Train() error = %v", err)
+	}
+	base := generateOptions{Prompt: "the", Max: 8, Seed: 4, Count: 50, Workers: 1, Format: "text", Delimiter: "\n--\n"}
+	var want bytes.Buffer
+	if err := base.Print(&want, model); err != nil {
+		t.Fatalf("Print() error = %v", err)
+	}
+	samples := strings.Split(strings.TrimSuffix(want.String(), "\n--\n"), "\n--\n")
+	distinct := make(map[string]bool)
+	for _, sample := range samples {
+		distinct[sample] = true
+	}
+	if len(samples) != 50 || len(distinct) < 10 {
+		t.Errorf("Print() wrote %d samples, %d distinct, want 50 independent samples", len(samples), len(distinct))
+	}
+
+	tests := []struct {
+		name    string
+		workers int
+		stream  bool
+	}{
+		{"Workers", 8, false},
+		{"More workers than samples", 80, false},
+		{"Stream", 1, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := base
+			opts.Workers, opts.Stream = tt.workers, tt.stream
+			var got bytes.Buffer
+			if err := opts.Print(&got, model); err != nil {
+				t.Fatalf("Print() error = %v", err)
+			}
+			if got.String() != want.String() {
+				t.Errorf("Print() = %q, want %q", got.String(), want.String())
+			}
+		})
+	}
+
+	// Each JSON line has the seed that generates its sample on its own
+	opts := base
+	opts.Format, opts.Workers = "jsonl", 4
+	var out bytes.Buffer
+	if err := opts.Print(&out, model); err != nil {
+		t.Fatalf("Print() error = %v", err)
+	}
+	lines := strings.Split(strings.TrimSuffix(out.String(), "\n"), "\n")
+	for i, line := range lines {
+		var got sample
+		if err := json.Unmarshal([]byte(line), &got); err != nil {
+			t.Fatalf("Print() line %q is not JSON: %v", line, err)
+		}
+		single := base
+		single.Seed = got.Seed
+		if want := (sample{Index: i, Seed: got.Seed, Text: single.Generate(model)}); got != want || got.Text != samples[i] {
+			t.Errorf("Print() line %d = %+v, want %+v", i, got, want)
+		}
+	}
+	if len(lines) != 50 {
+		t.Errorf("Print() wrote %d lines, want 50", len(lines))
+	}
+}
+
+func TestGenerateOptionsSampleSeed(t *testing.T) {
+	opts := generateOptions{Seed: 42}
+	seen := make(map[int64]bool)
+	for i := 0; i < 1000; i++ {
+		seed := opts.SampleSeed(i)
+		if seen[seed] {
+			t.Fatalf("SampleSeed(%d) = %d, which an earlier sample already used", i, seed)
+		}
+		seen[seed] = true
+	}
+	if got := opts.SampleSeed(0); got != 42 {
+		t.Errorf("SampleSeed(0) = %d, want the seed itself", got)
+	}
+	if other := (generateOptions{Seed: 43}).SampleSeed(1); seen[other] {
+		t.Errorf("SampleSeed(1) = %d for seed 43, which seed 42 also uses", other)
+	}
+}
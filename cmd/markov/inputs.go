@@ -0,0 +1,212 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// InputOptions controls which files are read when a directory is given as input
+type InputOptions struct {
+	// Include, if not empty, only reads files whose names match one of these patterns.
+	Include []string
+	// Exclude skips files whose names match one of these patterns.
+	Exclude []string
+}
+
+// StdinFilename is the input name that reads the corpus from standard input
+const StdinFilename = "-"
+
+// ResolveInputs expands args into the list of corpus files to train on. Each arg
+// may be a file, a directory, which is walked recursively, a glob pattern, or
+// StdinFilename.
+// Files found in directories are filtered by opts and hidden files, hidden
+// directories and histogram caches are skipped. Like in a shell, glob patterns
+// don't match hidden files. Files given explicitly are always read. Each file is returned once, in the order it was first found.
+func ResolveInputs(args []string, opts InputOptions) ([]string, error) {
+	for _, pattern := range append(append([]string{}, opts.Include...), opts.Exclude...) {
+		if _, err := filepath.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("invalid pattern \"%s\": %v", pattern, err)
+		}
+	}
+	var inputs []string
+	seen := make(map[string]bool)
+	add := func(filename string) error {
+		if filename == StdinFilename {
+			if seen[filename] {
+				return fmt.Errorf("standard input can only be read once")
+			}
+			seen[filename] = true
+			inputs = append(inputs, filename)
+			return nil
+		}
+		abs, err := filepath.Abs(filename)
+		if err != nil {
+			return err
+		}
+		if !seen[abs] {
+			seen[abs] = true
+			inputs = append(inputs, filename)
+		}
+		return nil
+	}
+
+	for _, arg := range args {
+		if arg == StdinFilename {
+			if err := add(arg); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		matches := []string{arg}
+		if isGlob(arg) {
+			var err error
+			if matches, err = filepath.Glob(arg); err != nil {
+				return nil, fmt.Errorf("invalid pattern \"%s\": %v", arg, err)
+			}
+			if len(matches) == 0 {
+				return nil, fmt.Errorf("pattern \"%s\" does not match any files", arg)
+			}
+		}
+		for _, match := range matches {
+			if match != arg && strings.HasPrefix(filepath.Base(match), ".") {
+				continue
+			}
+			info, err := os.Stat(match)
+			if os.IsNotExist(err) {
+				return nil, fmt.Errorf("input file \"%s\" does not exist", match)
+			} else if err != nil {
+				return nil, err
+			}
+			if !info.IsDir() {
+				if err := add(match); err != nil {
+					return nil, err
+				}
+				continue
+			}
+			err = filepath.Walk(match, func(path string, info os.FileInfo, err error) error {
+				if err != nil {
+					return err
+				}
+				name := info.Name()
+				if path != match && strings.HasPrefix(name, ".") {
+					if info.IsDir() {
+						return filepath.SkipDir
+					}
+					return nil
+				}
+				if info.IsDir() || !info.Mode().IsRegular() || isCacheFilename(name) || !opts.matches(name) {
+					return nil
+				}
+				return add(path)
+			})
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	if len(inputs) == 0 {
+		return nil, fmt.Errorf("no input files found in %s", strings.Join(args, ", "))
+	}
+	return inputs, nil
+}
+
+// matches reports whether name passes the include and exclude patterns of opts
+func (opts InputOptions) matches(name string) bool {
+	for _, pattern := range opts.Exclude {
+		if ok, _ := filepath.Match(pattern, name); ok {
+			return false
+		}
+	}
+	if len(opts.Include) == 0 {
+		return true
+	}
+	for _, pattern := range opts.Include {
+		if ok, _ := filepath.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+func isGlob(arg string) bool {
+	return strings.ContainsAny(arg, "*?[")
+}
+
+// isCacheFilename reports whether name looks like a histogram cache written by GetCacheFilename
+func isCacheFilename(name string) bool {
+	return strings.Contains(name, ".cache.n") && strings.HasSuffix(name, ".bin")
+}
+
+// inputFile is a corpus file that is only opened when it is first read, and
+// closed once it has been read to the end, so that training on many files never
+// holds more than one of them open at a time
+type inputFile struct {
+	filename string
+	file     *os.File
+	done     bool
+}
+
+func (f *inputFile) Read(p []byte) (int, error) {
+	if f.done {
+		return 0, io.EOF
+	}
+	if f.file == nil {
+		file, err := os.Open(f.filename)
+		if err != nil {
+			return 0, err
+		}
+		f.file = file
+	}
+	n, err := f.file.Read(p)
+	if err == io.EOF {
+		f.Close()
+	}
+	return n, err
+}
+
+func (f *inputFile) Close() error {
+	f.done = true
+	if f.file == nil {
+		return nil
+	}
+	err := f.file.Close()
+	f.file = nil
+	return err
+}
+
+// openInputs returns a reader for each of filenames. Files are opened as they
+// are read, and must be released with closeInputs. StdinFilename reads from
+// standard input.
+func openInputs(filenames []string) ([]io.Reader, error) {
+	inputs := make([]io.Reader, len(filenames))
+	for i, filename := range filenames {
+		if filename == StdinFilename {
+			inputs[i] = os.Stdin
+			continue
+		}
+		inputs[i] = &inputFile{filename: filename}
+	}
+	return inputs, nil
+}
+
+// isStream reports whether any of filenames is a stream, which can only be read once
+func isStream(filenames []string) bool {
+	for _, filename := range filenames {
+		if filename == StdinFilename {
+			return true
+		}
+	}
+	return false
+}
+
+// closeInputs closes any input files that weren't read to the end
+func closeInputs(inputs []io.Reader) {
+	for _, input := range inputs {
+		if file, ok := input.(*inputFile); ok {
+			file.Close()
+		}
+	}
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestResolveInputs(t *testing.T) {
+	dir, err := ioutil.TempDir("", "markov")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	files := []string{
+		"a.txt",
+		"b.md",
+		"nested/c.txt",
+		"nested/deeper/d.txt",
+		"nested/e.log",
+		".hidden.txt",
+		".git/f.txt",
+		"a.txt.cache.n3.bin",
+	}
+	for _, file := range files {
+		path := filepath.Join(dir, file)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(file), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	join := func(names ...string) []string {
+		paths := make([]string, len(names))
+		for i, name := range names {
+			paths[i] = filepath.Join(dir, name)
+		}
+		return paths
+	}
+
+	tests := []struct {
+		name    string
+		args    []string
+		opts    InputOptions
+		want    []string
+		wantErr bool
+	}{
+		{"File", join("a.txt"), InputOptions{}, join("a.txt"), false},
+		{"Files", join("b.md", "a.txt"), InputOptions{}, join("b.md", "a.txt"), false},
+		{"Explicit files ignore patterns", join(".hidden.txt", "b.md"), InputOptions{Include: []string{"*.txt"}}, join(".hidden.txt", "b.md"), false},
+		{"Directory", join("nested"), InputOptions{}, join("nested/c.txt", "nested/deeper/d.txt", "nested/e.log"), false},
+		{"Directory skips hidden files and caches", []string{dir}, InputOptions{}, join("a.txt", "b.md", "nested/c.txt", "nested/deeper/d.txt", "nested/e.log"), false},
+		{"Include", []string{dir}, InputOptions{Include: []string{"*.txt", "*.md"}}, join("a.txt", "b.md", "nested/c.txt", "nested/deeper/d.txt"), false},
+		{"Exclude", []string{dir}, InputOptions{Exclude: []string{"*.log", "d.*"}}, join("a.txt", "b.md", "nested/c.txt"), false},
+		{"Glob", join("*.txt", "nested/*"), InputOptions{Include: []string{"*.txt"}}, join("a.txt", "nested/c.txt", "nested/deeper/d.txt", "nested/e.log"), false},
+		{"Duplicates", append(join("a.txt"), dir), InputOptions{Include: []string{"*.txt"}}, join("a.txt", "nested/c.txt", "nested/deeper/d.txt"), false},
+		{"Standard input", append([]string{StdinFilename}, join("a.txt")...), InputOptions{}, append([]string{StdinFilename}, join("a.txt")...), false},
+		{"Standard input twice", []string{StdinFilename, StdinFilename}, InputOptions{}, nil, true},
+		{"Missing file", join("missing.txt"), InputOptions{}, nil, true},
+		{"Unmatched glob", join("*.csv"), InputOptions{}, nil, true},
+		{"No files found", join("nested"), InputOptions{Include: []string{"*.csv"}}, nil, true},
+		{"Invalid pattern", join("a.txt"), InputOptions{Exclude: []string{"["}}, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ResolveInputs(tt.args, tt.opts)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ResolveInputs() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ResolveInputs() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
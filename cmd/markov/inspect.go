@@ -0,0 +1,109 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sort"
+
+	"github.com/brannondorsey/markov/chain"
+	flag "github.com/spf13/pflag"
+)
+
+// InspectModel writes a description of the model saved in filename to w: the
+// options and corpus it was trained with, the size of its histogram, and its top
+// most frequent n-grams.
+func InspectModel(w io.Writer, filename string, top int) error {
+	file, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	header, err := chain.ReadHeader(file)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	model, err := chain.ReadModel(file)
+	if err != nil {
+		return err
+	}
+
+	opts := header.Options
+	fmt.Fprintf(w, "format version:  %d\n", header.Version)
+	fmt.Fprintf(w, "markov version:  %s\n", header.ToolVersion)
+	fmt.Fprintf(w, "mapped:          %t\n", header.Mapped)
+	fmt.Fprintf(w, "n-gram length:   %d\n", opts.N)
+	fmt.Fprintf(w, "mode:            %v\n", opts.Mode)
+	fmt.Fprintf(w, "tokenizer:       %s\n", header.Tokenizer)
+	fmt.Fprintf(w, "detokenizer:     %s\n", header.Detokenizer)
+	fmt.Fprintf(w, "lowercase:       %t\n", opts.Lowercase)
+	fmt.Fprintf(w, "normalization:   %v\n", opts.Normalization)
+	fmt.Fprintf(w, "case fold:       %t\n", opts.CaseFold)
+	fmt.Fprintf(w, "strip accents:   %t\n", opts.StripAccents)
+	fmt.Fprintf(w, "strip control:   %t\n", opts.StripControl)
+	fmt.Fprintf(w, "backoff:         %t\n", opts.Backoff)
+	fmt.Fprintf(w, "segment:         %v\n", opts.Segment)
+	fmt.Fprintf(w, "corpus hash:     %s\n", header.Corpus.Hash)
+
+	hist := model.Histogram()
+	frequencies := make(map[string]uint64, len(hist))
+	var transitions int
+	var total uint64
+	for gram, nextGrams := range hist {
+		transitions += len(nextGrams)
+		for _, frequency := range nextGrams {
+			frequencies[gram] += uint64(frequency)
+		}
+		total += frequencies[gram]
+	}
+	fmt.Fprintf(w, "n-grams:         %d\n", len(hist))
+	fmt.Fprintf(w, "vocabulary:      %d\n", len(model.Vocabulary()))
+	fmt.Fprintf(w, "transitions:     %d distinct, %d total\n", transitions, total)
+
+	grams := make([]string, 0, len(frequencies))
+	for gram := range frequencies {
+		grams = append(grams, gram)
+	}
+	sort.Slice(grams, func(i, j int) bool {
+		if frequencies[grams[i]] != frequencies[grams[j]] {
+			return frequencies[grams[i]] > frequencies[grams[j]]
+		}
+		return grams[i] < grams[j]
+	})
+	if top < len(grams) {
+		grams = grams[:top]
+	}
+	if len(grams) > 0 {
+		fmt.Fprintf(w, "most frequent n-grams:\n")
+	}
+	for _, gram := range grams {
+		fmt.Fprintf(w, "  %10d  %q\n", frequencies[gram], gram)
+	}
+	return nil
+}
+
+// runInspect implements the inspect command
+func runInspect(args []string) {
+	flags := flag.NewFlagSet("inspect", flag.ExitOnError)
+	modelFilename := flags.String("model", "", "The model file to describe.")
+	top := flags.Int("top", 10, "The number of most frequent n-grams to list.")
+	help := flags.BoolP("help", "h", false, "Show this screen.")
+
+	flags.Usage = func() {
+		fmt.Printf("Usage: %s inspect [OPTIONS] --model <model>\n", os.Args[0])
+		fmt.Println("Describes the options, corpus and histogram of a model file.")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+	if flags.NArg() > 0 || *modelFilename == "" || *help {
+		flags.Usage()
+		os.Exit(1)
+	}
+	if err := InspectModel(os.Stdout, *modelFilename, *top); err != nil {
+		fmt.Printf("[ERROR] Failed to inspect the model %s: %v.\n", *modelFilename, err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/brannondorsey/markov/chain"
+)
+
+func TestInspectModel(t *testing.T) {
+	dir, err := ioutil.TempDir("", "markov")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	model := chain.New(chain.Options{N: 1, Mode: chain.TokenMode, Lowercase: true})
+	if err := model.Train(strings.NewReader("abab")); err != nil {
+		t.Fatalf("Train() error = %v", err)
+	}
+	modelFilename := filepath.Join(dir, "model.bin")
+	if err := CacheModel(model, modelFilename); err != nil {
+		t.Fatalf("CacheModel() error = %v", err)
+	}
+	mappedFilename := filepath.Join(dir, "mapped.bin")
+	if err := SaveMappedModel(model, mappedFilename); err != nil {
+		t.Fatalf("SaveMappedModel() error = %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		filename string
+		top      int
+		want     []string
+		wantN    int
+	}{
+		{"Summary", modelFilename, 0, []string{"mapped:          false\n", "n-gram length:   1\n", "mode:            token\n", "lowercase:       true\n", "transitions:     2 distinct, 3 total\n"}, 0},
+		{"Top n-grams", modelFilename, 1, []string{"most frequent n-grams:\n", "2  \"a\"\n"}, 1},
+		{"Mapped", mappedFilename, 1, []string{"mapped:          true\n", "transitions:     2 distinct, 3 total\n", "2  \"a\"\n"}, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out bytes.Buffer
+			if err := InspectModel(&out, tt.filename, tt.top); err != nil {
+				t.Fatalf("InspectModel() error = %v", err)
+			}
+			for _, want := range tt.want {
+				if !strings.Contains(out.String(), want) {
+					t.Errorf("InspectModel() = %q, want it to contain %q", out.String(), want)
+				}
+			}
+			if got := strings.Count(out.String(), "  \""); got != tt.wantN {
+				t.Errorf("InspectModel() listed %d n-grams, want %d", got, tt.wantN)
+			}
+		})
+	}
+
+	if err := InspectModel(ioutil.Discard, filepath.Join(dir, "missing.bin"), 10); err == nil {
+		t.Errorf("InspectModel() error = nil, want error for missing model")
+	}
+}
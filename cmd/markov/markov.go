@@ -1,250 +1,117 @@
 package main
 
 import (
-	"bufio"
-	"encoding/json"
 	"fmt"
-	"io"
-	"io/ioutil"
-	"math/rand"
 	"os"
-	"strings"
-	"time"
 
-	wr "github.com/mroth/weightedrand"
+	"github.com/brannondorsey/markov/chain"
 	flag "github.com/spf13/pflag"
 )
 
-type StringHistogram = map[string]map[string]uint32
-
-func main() {
-
-	args := parseArgs()
-
-	rand.Seed(time.Now().UTC().UnixNano()) // always seed random!
-	hist, err := LoadOrCreateHistogram(args.InputFilename, args.N, args.Lowercase, args.Words)
-	if err != nil {
-		panic(err)
-	}
-	sample := GetSamplerFromStringHistogram(hist)
-	generated := make([]string, 0, args.Max)
-	generated = append(generated, GetSeed(args.Prompt, args.N, args.Lowercase, args.Words, hist)...)
-	for i := 0; i < args.Max; i++ {
-		next, err := sample(generated[len(generated)-1])
-		if err != nil {
-			break
-		}
-		generated = append(generated, next)
-	}
-	fmt.Println(strings.Join(generated, GetSeparator(args.Words)))
+// commands are the subcommands of markov, by name
+var commands = map[string]func(args []string){
+	"train":    runTrain,
+	"generate": runGenerate,
+	"eval":     runEval,
+	"inspect":  runInspect,
+	"serve":    runServe,
 }
 
-// GetSeparator returns " " if words is true, "" otherwise
-func GetSeparator(words bool) string {
-	if words {
-		return " "
-	} else {
-		return ""
-	}
-}
+func main() {
 
-// GetSeed splits prompt into n-grams if prompt is usable or returns a random n-gram if not
-func GetSeed(prompt string, n int, lower bool, words bool, hist StringHistogram) []string {
-	var seed []string
-	separator := GetSeparator(words)
-	if lower {
-		prompt = strings.ToLower(prompt)
-	}
-	// If the prompt contains at least one n-gram's worth of text
-	if promptSplit := strings.Split(prompt, separator); prompt != "" && len(promptSplit) >= n {
-		// Split it on the last n-gram
-		first := strings.Join(promptSplit[:len(promptSplit)-n], separator)
-		last := strings.Join(promptSplit[len(promptSplit)-n:], separator)
-		// fmt.Printf("first: %v, last: %v\n", first, last)
-		// And if the ngram appears in the corpus histogram
-		if _, ok := hist[last]; ok {
-			// Return []string{first, last}
-			if first != "" {
-				seed = append(seed, first)
-			}
-			seed = append(seed, last)
-			return seed
+	if len(os.Args) > 1 {
+		if run, ok := commands[os.Args[1]]; ok {
+			run(os.Args[2:])
+			return
 		}
 	}
-	// Use the first random ngram that contains at least one child
-	for randNgram := range hist {
-		if len(hist[randNgram]) < 1 {
-			continue
-		}
-		seed = []string{randNgram}
-		break
-	}
-	return seed
-}
 
-func BuildStringHistogram(r io.Reader, n int, lowercase bool, words bool) StringHistogram {
-	frequency := make(StringHistogram)
-	scanner := bufio.NewScanner(r)
-	separator := GetSeparator(words)
-	if words {
-		scanner.Split(bufio.ScanWords)
-	} else {
-		scanner.Split(bufio.ScanRunes)
-	}
-	buf := make([]string, 0, n)
-	for scanner.Scan() {
-		text := scanner.Text()
-		buf = append(buf, text)
-		if len(buf) > n*2 {
-			gram := strings.Join(buf[0:n], separator)
-			nextGram := strings.Join(buf[n:len(buf)-1], separator)
-			if lowercase {
-				gram = strings.ToLower(gram)
-				nextGram = strings.ToLower(nextGram)
-			}
-			// fmt.Printf("gram: %v, nextGram: %v\n", gram, nextGram)
-			if _, ok := frequency[gram]; !ok {
-				frequency[gram] = make(map[string]uint32)
-			}
-			frequency[gram][nextGram]++
-			buf = buf[1:]
-		}
-	}
-	return frequency
-}
+	// Without a subcommand, train or load a cached model and generate from it in one go
+	args := parseArgs()
 
-func GetSamplerFromStringHistogram(hist StringHistogram) func(string) (string, error) {
-	samplers := make(map[string]*wr.Chooser)
-	for gram := range hist {
-		nextGrams := hist[gram]
-		choices := make([]wr.Choice, len(nextGrams))
-		i := 0
-		for key := range nextGrams {
-			// fmt.Println(i, key, hist[key])
-			choices[i] = wr.Choice{
-				Item:   key,
-				Weight: uint(nextGrams[key]),
-			}
-			i++
-		}
-		chooser := wr.NewChooser(choices...)
-		samplers[gram] = &chooser
+	model, err := LoadOrCreateModel(args.InputFilenames, args.Options, args.Cache, args.Train)
+	if err != nil {
+		fmt.Printf("[ERROR] Failed to train the model: %v.\n", err)
+		os.Exit(1)
 	}
-	return func(search string) (string, error) {
-		if _, ok := samplers[search]; !ok {
-			return "", fmt.Errorf("sample error: %v was not present in the histogram", search)
+	if args.ExportJSON != "" {
+		if err := ExportModelJSON(model, args.ExportJSON); err != nil {
+			fmt.Printf("[ERROR] Failed to export the histogram: %v.\n", err)
+			os.Exit(1)
 		}
-		return samplers[search].Pick().(string), nil
 	}
-}
-
-// func PrintStringHistogram(hist StringHistogram) {
-// 	for key := range hist {
-// 		fmt.Printf("%v: %v\n", key, hist[key])
-// 	}
-// }
-
-func LoadOrCreateHistogram(filename string, n int, lowercase bool, words bool) (StringHistogram, error) {
-	lowercaseString := ""
-	if lowercase {
-		lowercaseString = "lower"
-	}
-	wordsString := ""
-	if words {
-		wordsString = "words"
-	}
-	cacheFilename := fmt.Sprintf("%v.cache.n%d%s%s.json", filename, n, lowercaseString, wordsString)
-	cacheFile, err := os.Open(cacheFilename)
-	// Load from cache
-	if err == nil {
-		defer cacheFile.Close()
-		cacheBytes, err := ioutil.ReadAll(cacheFile)
-		if err != nil {
-			return nil, err
-		}
-		hist := make(StringHistogram)
-		err = json.Unmarshal(cacheBytes, &hist)
-		if err != nil {
-			return nil, err
-		}
-		return hist, nil
-	} else if os.IsNotExist(err) {
-		// Build histogram and save cache
-		file, err := os.Open(filename)
-		if err != nil {
-			return nil, err
-		}
-		defer file.Close()
-		hist := BuildStringHistogram(file, n, lowercase, words)
-		err = CacheHistogram(hist, cacheFilename)
-		if err != nil {
-			return nil, err
-		}
-		return hist, nil
+	if err := args.Generate.Print(os.Stdout, model); err != nil {
+		fmt.Printf("[ERROR] Failed to write the generated text: %v.\n", err)
+		os.Exit(1)
 	}
-	return nil, err
 }
 
-func CacheHistogram(histogram StringHistogram, filename string) error {
+// ExportModelJSON writes the histogram of model to filename as JSON
+func ExportModelJSON(model *chain.Model, filename string) error {
 	file, err := os.Create(filename)
 	if err != nil {
 		return err
 	}
 	defer file.Close()
-	serialized, err := json.Marshal(histogram)
-	if err != nil {
-		return err
-	}
-	_, err = file.WriteString(string(serialized))
-	return err
+	return model.ExportJSON(file)
 }
 
 type arguments struct {
-	InputFilename string
-	Prompt        string
-	N             int
-	Max           int
-	Lowercase     bool
-	Words         bool
+	InputFilenames []string
+	Options        chain.Options
+	Generate       generateOptions
+	ExportJSON     string
+	Cache          CacheOptions
+	Train          TrainOptions
 }
 
 func parseArgs() arguments {
-	prompt := flag.StringP("prompt", "p", "", "The prompt to use.")
-	n := flag.IntP("n-gram-length", "n", 3, "The number of characters to use for each n-gram.")
-	max := flag.IntP("max", "m", 1000, "The maximum number of n-gram tokens to generate. Fewer characters may begenerated if\nthe sequence encounters an n-gram that has no next n-grams in the dataset.")
+	train := addTrainFlags(flag.CommandLine)
+	generate := addGenerateFlags(flag.CommandLine)
 	help := flag.BoolP("help", "h", false, "Show this screen.")
-	lowercase := flag.BoolP("lowercase", "l", false, "Convert text to lowercase. Lowers the complexity of the sampling task, and may produce\nbetter results depending on the corpus.")
-	words := flag.BoolP("words", "w", false, "Use word-level n-grams instead of character-level n-grams.")
+	exportJSON := flag.String("export-json", "", "Also write the n-gram histogram to this file as JSON.")
+	rebuildCache := flag.Bool("rebuild-cache", false, "Rebuild the cached histogram even if it is up to date.")
+	noCache := flag.Bool("no-cache", false, "Don't read or write a cached histogram.")
+	cacheDir := flag.String("cache-dir", "", "The directory to cache histograms in. Defaults to the directory of the input file, or\nthe user cache directory if there are several input files.")
 
 	flag.Parse()
 	flag.Usage = func() {
-		fmt.Printf("Usage: %s [OPTIONS] <input-file> ...\n", os.Args[0])
-		fmt.Println("Note: <input-file> is a required positional argument.")
+		fmt.Printf("Usage: %s [OPTIONS] <input> [<input> ...]\n", os.Args[0])
+		fmt.Printf("       %s <command> [OPTIONS]\n", os.Args[0])
+		fmt.Println("Note: at least one <input> is required. Inputs may be files, directories, which are read")
+		fmt.Println("recursively, glob patterns, or - to read standard input. One histogram is built from all")
+		fmt.Println("of the inputs. Inputs compressed with gzip, bzip2 or zstd, and tar archives, are read")
+		fmt.Println("transparently. Histograms built from standard input are not cached.")
+		fmt.Println()
+		fmt.Println("Without a command, markov trains a model on the inputs, or loads it from its cache, and")
+		fmt.Println("generates text from it. The commands do one step at a time:")
+		fmt.Println("  train      Train a model on the inputs and save it to a model file.")
+		fmt.Println("  generate   Generate text from a model file.")
+		fmt.Println("  eval       Score how well a model file predicts held-out text.")
+		fmt.Println("  inspect    Describe a model file.")
+		fmt.Println("  serve      Serve text generated from model files over HTTP.")
+		fmt.Printf("Run %s <command> --help for the options of each command.\n", os.Args[0])
+		fmt.Println()
 		flag.PrintDefaults()
 	}
-	if flag.NArg() != 1 || *help {
+	if flag.NArg() < 1 || *help {
 		flag.Usage()
 		os.Exit(1)
 	}
-	if *n < 1 || *n > 6 {
-		fmt.Printf("[ERROR] The value of --n-gram-length must be between 1 and 6. Received %d.\n", *n)
-		os.Exit(1)
-	}
-	inputFilename := flag.Args()[0]
-	if fileInfo, err := os.Stat(inputFilename); os.IsNotExist(err) || fileInfo.IsDir() {
-		if fileInfo != nil && fileInfo.IsDir() {
-			fmt.Printf("[ERROR] Input file \"%s\" must be a file, not a directory.\n", inputFilename)
-		} else {
-			fmt.Printf("[ERROR] Input file \"%s\" does not exist.\n", inputFilename)
-		}
-		os.Exit(1)
-	}
+	opts := train.options()
+	generateOpts := generate.options()
+	// Backing off and interpolated smoothing both use the shorter contexts
+	opts.Backoff = opts.Backoff || generateOpts.DeadEnd == chain.Backoff || generateOpts.Estimator.Smoothing >= chain.WittenBell
 	return arguments{
-		InputFilename: inputFilename,
-		Prompt:        *prompt,
-		N:             *n,
-		Max:           *max,
-		Lowercase:     *lowercase,
-		Words:         *words,
+		InputFilenames: train.inputs(flag.Args()),
+		Options:        opts,
+		Generate:       generateOpts,
+		ExportJSON:     *exportJSON,
+		Cache: CacheOptions{
+			Dir:      *cacheDir,
+			Rebuild:  *rebuildCache,
+			Disabled: *noCache,
+		},
+		Train: train.training(),
 	}
 }
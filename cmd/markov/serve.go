@@ -0,0 +1,377 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/brannondorsey/markov/chain"
+	flag "github.com/spf13/pflag"
+)
+
+// ServerOptions limits the work a Server does for each request
+type ServerOptions struct {
+	// Timeout is how long generating text for a request may take before it
+	// stops and the request fails with 503 Service Unavailable. 0 means no
+	// timeout.
+	Timeout time.Duration
+	// MaxTokens is the largest max a generate request may ask for.
+	MaxTokens int
+}
+
+// maxRequestBytes is the largest request body a Server reads
+const maxRequestBytes = 1 << 20
+
+// GenerateRequest is the body of a POST /generate request. Zero values use the
+// same defaults as the generate command.
+type GenerateRequest struct {
+	// Model is the name of the model to generate from. It may be left out if
+	// the server has only one model.
+	Model       string  `json:"model"`
+	Prompt      string  `json:"prompt"`
+	Max         int     `json:"max"`
+	Seed        *int64  `json:"seed"`
+	DeadEnd     string  `json:"dead_end"`
+	Temperature float64 `json:"temperature"`
+	TopK        int     `json:"top_k"`
+	TopP        float64 `json:"top_p"`
+	Greedy      bool    `json:"greedy"`
+	Smoothing   string  `json:"smoothing"`
+	AddK        float64 `json:"add_k"`
+	Discount    float64 `json:"discount"`
+	Segments    int     `json:"segments"`
+	// Stream sends the text as server-sent events as it is generated: a
+	// StreamEvent for each piece of text, then a "done" event with the
+	// GenerateResponse without its text, or an "error" event.
+	Stream bool `json:"stream"`
+}
+
+// StreamEvent is the data of each event of a streamed POST /generate response
+type StreamEvent struct {
+	Text string `json:"text"`
+}
+
+// GenerateResponse is the body of a successful POST /generate response
+type GenerateResponse struct {
+	Model string `json:"model"`
+	Text  string `json:"text,omitempty"`
+	// Seed is the random seed that was used, so the text can be generated again.
+	Seed int64 `json:"seed"`
+}
+
+// ModelInfo describes a model in the body of a GET /models response
+type ModelInfo struct {
+	Name       string `json:"name"`
+	N          int    `json:"n"`
+	Mode       string `json:"mode"`
+	Lowercase  bool   `json:"lowercase"`
+	Words      bool   `json:"words"`
+	Tokenizer  string `json:"tokenizer"`
+	Backoff    bool   `json:"backoff"`
+	Segment    string `json:"segment"`
+	CorpusHash string `json:"corpus_hash"`
+}
+
+type errorResponse struct {
+	Error string `json:"error"`
+}
+
+// Server serves text generated from a set of models over HTTP:
+//
+//	POST /generate  generates text from a GenerateRequest
+//	GET  /models    lists the models
+//	GET  /healthz   reports that the server is up
+//
+// Models are loaded once and shared by every request, each of which samples
+// with its own Generator.
+type Server struct {
+	models map[string]*chain.Model
+	opts   ServerOptions
+	mux    *http.ServeMux
+}
+
+// NewServer returns a Server for models, by name.
+func NewServer(models map[string]*chain.Model, opts ServerOptions) *Server {
+	s := &Server{models: models, opts: opts, mux: http.NewServeMux()}
+	s.mux.HandleFunc("/generate", s.handleGenerate)
+	s.mux.HandleFunc("/models", s.handleModels)
+	s.mux.HandleFunc("/healthz", s.handleHealthz)
+	return s
+}
+
+// ServeHTTP implements http.Handler.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mux.ServeHTTP(w, r)
+}
+
+func (s *Server) handleGenerate(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, "use POST")
+		return
+	}
+	var req GenerateRequest
+	decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxRequestBytes))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid request: %v", err))
+		return
+	}
+
+	if req.Model == "" && len(s.models) != 1 {
+		writeError(w, http.StatusBadRequest, "model is required when serving several models")
+		return
+	}
+	name, model, ok := s.model(req.Model)
+	if !ok {
+		writeError(w, http.StatusNotFound, fmt.Sprintf("unknown model %q", req.Model))
+		return
+	}
+	max := req.Max
+	if max == 0 {
+		max = 1000
+		if s.opts.MaxTokens > 0 && max > s.opts.MaxTokens {
+			max = s.opts.MaxTokens
+		}
+	}
+	if max < 0 {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("max must not be negative, got %d", max))
+		return
+	} else if s.opts.MaxTokens > 0 && max > s.opts.MaxTokens {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("max must be at most %d, got %d", s.opts.MaxTokens, max))
+		return
+	}
+	seed := time.Now().UTC().UnixNano()
+	if req.Seed != nil {
+		seed = *req.Seed
+	}
+	generator, err := newRequestGenerator(model, seed, req)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	// Generation stops once the request times out or the client goes away
+	ctx := r.Context()
+	if s.opts.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, s.opts.Timeout)
+		defer cancel()
+	}
+	resp := GenerateResponse{Model: name, Seed: seed}
+	if req.Stream {
+		streamGenerate(ctx, w, generator, req.Prompt, max, resp)
+		return
+	}
+	var text strings.Builder
+	err = generator.GenerateTo(req.Prompt, max, func(piece string) error {
+		text.WriteString(piece)
+		return ctx.Err()
+	})
+	if err != nil {
+		writeError(w, http.StatusServiceUnavailable, "request timed out")
+		return
+	}
+	resp.Text = text.String()
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// streamGenerate writes the text sampled by generator to w as server-sent
+// events, followed by resp
+func streamGenerate(ctx context.Context, w http.ResponseWriter, generator *chain.Generator, prompt string, max int, resp GenerateResponse) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeError(w, http.StatusInternalServerError, "streaming is not supported")
+		return
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	err := generator.GenerateTo(prompt, max, func(piece string) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		writeEvent(w, "", StreamEvent{Text: piece})
+		flusher.Flush()
+		return nil
+	})
+	if err != nil {
+		writeEvent(w, "error", errorResponse{Error: "request timed out"})
+	} else {
+		writeEvent(w, "done", resp)
+	}
+	flusher.Flush()
+}
+
+// writeEvent writes a server-sent event whose data is v encoded as JSON. An
+// empty name writes an unnamed message event.
+func writeEvent(w io.Writer, name string, v interface{}) {
+	data, _ := json.Marshal(v)
+	if name != "" {
+		fmt.Fprintf(w, "event: %s\n", name)
+	}
+	fmt.Fprintf(w, "data: %s\n\n", data)
+}
+
+// model returns the model called name, or the only model if name is empty
+func (s *Server) model(name string) (string, *chain.Model, bool) {
+	if name == "" && len(s.models) == 1 {
+		for name, model := range s.models {
+			return name, model, true
+		}
+	}
+	model, ok := s.models[name]
+	return name, model, ok
+}
+
+// newRequestGenerator returns a Generator for model seeded with seed and
+// configured by req
+func newRequestGenerator(model *chain.Model, seed int64, req GenerateRequest) (*chain.Generator, error) {
+	generator := chain.NewGenerator(model, seed)
+	if req.DeadEnd != "" {
+		deadEnd, err := chain.ParseDeadEnd(req.DeadEnd)
+		if err != nil {
+			return nil, err
+		}
+		generator.DeadEnd = deadEnd
+	}
+	generator.Sampling = chain.Sampling{Temperature: req.Temperature, TopK: req.TopK, TopP: req.TopP, Greedy: req.Greedy}
+	if err := generator.Sampling.Validate(); err != nil {
+		return nil, err
+	}
+	if req.Smoothing != "" {
+		smoothing, err := chain.ParseSmoothing(req.Smoothing)
+		if err != nil {
+			return nil, err
+		}
+		generator.Estimator.Smoothing = smoothing
+	}
+	generator.Estimator.K = req.AddK
+	generator.Estimator.Discount = req.Discount
+	if err := generator.Estimator.Validate(); err != nil {
+		return nil, err
+	}
+	if req.Segments < 0 {
+		return nil, fmt.Errorf("segments must not be negative, got %d", req.Segments)
+	}
+	generator.Segments = req.Segments
+	return generator, nil
+}
+
+func (s *Server) handleModels(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "use GET")
+		return
+	}
+	infos := make([]ModelInfo, 0, len(s.models))
+	for name, model := range s.models {
+		opts := model.Options
+		infos = append(infos, ModelInfo{
+			Name:       name,
+			N:          opts.N,
+			Mode:       opts.Mode.String(),
+			Lowercase:  opts.Lowercase,
+			Words:      opts.Words,
+			Tokenizer:  model.Tokenizer().Name(),
+			Backoff:    opts.Backoff,
+			Segment:    opts.Segment.String(),
+			CorpusHash: model.Corpus.Hash,
+		})
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Name < infos[j].Name })
+	writeJSON(w, http.StatusOK, infos)
+}
+
+func (s *Server) handleHealthz(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, errorResponse{Error: message})
+}
+
+// LoadModels loads each of filenames, named after the file without its
+// extension. A filename may instead be given as name=filename.
+func LoadModels(filenames []string) (map[string]*chain.Model, error) {
+	models := make(map[string]*chain.Model, len(filenames))
+	for _, filename := range filenames {
+		name := strings.TrimSuffix(filepath.Base(filename), filepath.Ext(filename))
+		if i := strings.Index(filename, "="); i >= 0 {
+			name, filename = filename[:i], filename[i+1:]
+		}
+		if _, ok := models[name]; ok {
+			return nil, fmt.Errorf("two models are named %q, name them with name=filename", name)
+		}
+		model, err := LoadModel(filename)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load the model %s: %v", filename, err)
+		}
+		models[name] = model
+	}
+	return models, nil
+}
+
+// runServe implements the serve command
+func runServe(args []string) {
+	flags := flag.NewFlagSet("serve", flag.ExitOnError)
+	modelFilenames := flags.StringArray("model", nil, "A model file to serve, written by the train command. May be repeated. Models are named\nafter the file without its extension, or given as name=filename.")
+	addr := flags.String("addr", "localhost:8080", "The address to listen on.")
+	timeout := flags.Duration("timeout", 30*time.Second, "How long generating text for a request may take. 0 means no timeout.")
+	maxTokens := flags.Int("max-tokens", 10000, "The largest max a generate request may ask for.")
+	help := flags.BoolP("help", "h", false, "Show this screen.")
+
+	flags.Usage = func() {
+		fmt.Printf("Usage: %s serve [OPTIONS] --model <model> [--model <model> ...]\n", os.Args[0])
+		fmt.Println("Serves text generated from model files over HTTP:")
+		fmt.Println("  POST /generate  Generate text. The JSON body may set model, prompt, max, seed,")
+		fmt.Println("                  dead_end, temperature, top_k, top_p, greedy, smoothing, add_k,")
+		fmt.Println("                  discount, segments and stream. The response is")
+		fmt.Println("                  {\"model\", \"text\", \"seed\"}. With \"stream\": true it is a")
+		fmt.Println("                  text/event-stream of server-sent events instead: a message event")
+		fmt.Println("                  with {\"text\"} for each piece of text as it is generated, then a")
+		fmt.Println("                  \"done\" event with {\"model\", \"seed\"}, or an \"error\" event with")
+		fmt.Println("                  {\"error\"}.")
+		fmt.Println("  GET  /models    List the models.")
+		fmt.Println("  GET  /healthz   Report that the server is up.")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+	if flags.NArg() > 0 || len(*modelFilenames) == 0 || *help {
+		flags.Usage()
+		os.Exit(1)
+	}
+	if *timeout < 0 || *maxTokens < 1 {
+		fmt.Printf("[ERROR] --timeout must not be negative and --max-tokens must be at least 1.\n")
+		os.Exit(1)
+	}
+	models, err := LoadModels(*modelFilenames)
+	if err != nil {
+		fmt.Printf("[ERROR] %v.\n", err)
+		os.Exit(1)
+	}
+
+	server := &http.Server{
+		Addr:              *addr,
+		Handler:           NewServer(models, ServerOptions{Timeout: *timeout, MaxTokens: *maxTokens}),
+		ReadHeaderTimeout: 10 * time.Second,
+		ReadTimeout:       time.Minute,
+		IdleTimeout:       2 * time.Minute,
+	}
+	fmt.Printf("Serving %d model(s) on http://%s\n", len(models), *addr)
+	if err := server.ListenAndServe(); err != nil {
+		fmt.Printf("[ERROR] %v.\n", err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,219 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/brannondorsey/markov/chain"
+)
+
+func newTestModels(t *testing.T) map[string]*chain.Model {
+	text := "Hello world! This is a text string to be used during testing. Its short. " +
+		"This text is everything before the end of the test, and the end is near."
+	chars := chain.New(chain.Options{N: 2})
+	words := chain.New(chain.Options{N: 1, Mode: chain.TokenMode, Words: true, Backoff: true})
+	for _, model := range []*chain.Model{chars, words} {
+		if err := model.Train(strings.NewReader(text)); err != nil {
+			t.Fatalf("Train() error = %v", err)
+		}
+	}
+	return map[string]*chain.Model{"chars": chars, "words": words}
+}
+
+func TestServer(t *testing.T) {
+	models := newTestModels(t)
+	server := httptest.NewServer(NewServer(models, ServerOptions{Timeout: time.Minute, MaxTokens: 100}))
+	defer server.Close()
+
+	seeded := chain.NewGenerator(models["chars"], 42)
+	tests := []struct {
+		name       string
+		method     string
+		path       string
+		body       string
+		wantStatus int
+		want       string
+	}{
+		{"Health", "GET", "/healthz", "", http.StatusOK, `{"status":"ok"}`},
+		{"Models", "GET", "/models", "", http.StatusOK, `[{"name":"chars","n":2,"mode":"ngram","lowercase":false,"words":false,"tokenizer":"runes","backoff":false,"segment":"none","corpus_hash":"` + models["chars"].Corpus.Hash + `"},` +
+			`{"name":"words","n":1,"mode":"token","lowercase":false,"words":true,"tokenizer":"words","backoff":true,"segment":"none","corpus_hash":"` + models["words"].Corpus.Hash + `"}]`},
+		{"Generate", "POST", "/generate", `{"model":"chars","prompt":"Th","max":30,"seed":42}`, http.StatusOK,
+			`{"model":"chars","text":` + jsonString(t, seeded.Generate("Th", 30)) + `,"seed":42}`},
+		{"Greedy", "POST", "/generate", `{"model":"words","prompt":"the","max":3,"seed":1,"greedy":true}`, http.StatusOK,
+			`{"model":"words","text":"the end is a","seed":1}`},
+		{"Models wrong method", "POST", "/models", "", http.StatusMethodNotAllowed, `{"error":"use GET"}`},
+		{"Generate wrong method", "GET", "/generate", "", http.StatusMethodNotAllowed, `{"error":"use POST"}`},
+		{"Missing model", "POST", "/generate", `{"prompt":"Th"}`, http.StatusBadRequest, `{"error":"model is required when serving several models"}`},
+		{"Unknown model", "POST", "/generate", `{"model":"poems"}`, http.StatusNotFound, `{"error":"unknown model \"poems\""}`},
+		{"Max too large", "POST", "/generate", `{"model":"chars","max":1000}`, http.StatusBadRequest, `{"error":"max must be at most 100, got 1000"}`},
+		{"Invalid sampling", "POST", "/generate", `{"model":"chars","top_p":2}`, http.StatusBadRequest, `{"error":"top-p must be between 0 and 1, got 2"}`},
+		{"Invalid smoothing", "POST", "/generate", `{"model":"chars","smoothing":"good-turing"}`, http.StatusBadRequest,
+			`{"error":"unknown smoothing \"good-turing\", must be one of mle, add-k, witten-bell, absolute-discounting, kneser-ney"}`},
+		{"Unknown field", "POST", "/generate", `{"model":"chars","length":10}`, http.StatusBadRequest, `{"error":"invalid request: json: unknown field \"length\""}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := http.NewRequest(tt.method, server.URL+tt.path, strings.NewReader(tt.body))
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+			body, err := ioutil.ReadAll(resp.Body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if resp.StatusCode != tt.wantStatus || strings.TrimSpace(string(body)) != tt.want {
+				t.Errorf("%s %s = %d %s, want %d %s", tt.method, tt.path, resp.StatusCode, body, tt.wantStatus, tt.want)
+			}
+		})
+	}
+}
+
+func jsonString(t *testing.T, s string) string {
+	b, err := json.Marshal(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(b)
+}
+
+func TestServerConcurrent(t *testing.T) {
+	models := newTestModels(t)
+	handler := NewServer(models, ServerOptions{})
+	generate := func(smoothing string) GenerateResponse {
+		body := `{"model":"words","prompt":"this","max":50,"seed":7,"temperature":1.5,"smoothing":"` + smoothing + `"}`
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, httptest.NewRequest("POST", "/generate", strings.NewReader(body)))
+		var resp GenerateResponse
+		if err := json.Unmarshal(recorder.Body.Bytes(), &resp); err != nil {
+			t.Errorf("POST /generate = %s, want JSON: %v", recorder.Body, err)
+		}
+		return resp
+	}
+
+	// Every request builds the lazily built samplers at the same time
+	for _, smoothing := range []string{"mle", "kneser-ney"} {
+		responses := make([]GenerateResponse, 20)
+		var wg sync.WaitGroup
+		for i := range responses {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				responses[i] = generate(smoothing)
+			}(i)
+		}
+		wg.Wait()
+		for _, resp := range responses {
+			if !reflect.DeepEqual(resp, responses[0]) {
+				t.Errorf("%s: POST /generate = %+v, want %+v for the same seed", smoothing, resp, responses[0])
+			}
+		}
+	}
+}
+
+func TestServerTimeout(t *testing.T) {
+	handler := NewServer(newTestModels(t), ServerOptions{Timeout: time.Nanosecond})
+	recorder := httptest.NewRecorder()
+	body := `{"model":"chars","max":100000,"smoothing":"kneser-ney"}`
+	handler.ServeHTTP(recorder, httptest.NewRequest("POST", "/generate", strings.NewReader(body)))
+	if recorder.Code != http.StatusServiceUnavailable {
+		t.Errorf("POST /generate = %d %s, want %d", recorder.Code, recorder.Body, http.StatusServiceUnavailable)
+	}
+}
+
+func TestLoadModels(t *testing.T) {
+	dir, err := ioutil.TempDir("", "markov")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	for name, model := range newTestModels(t) {
+		if err := CacheModel(model, filepath.Join(dir, name+".bin")); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name      string
+		filenames []string
+		want      []string
+		wantErr   bool
+	}{
+		{"Named after files", []string{"chars.bin", "words.bin"}, []string{"chars", "words"}, false},
+		{"Explicit names", []string{"a=chars.bin", "b=chars.bin"}, []string{"a", "b"}, false},
+		{"Duplicate names", []string{"chars.bin", "chars=words.bin"}, nil, true},
+		{"Missing file", []string{"missing.bin"}, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var filenames []string
+			for _, filename := range tt.filenames {
+				if i := strings.Index(filename, "="); i >= 0 {
+					filenames = append(filenames, filename[:i+1]+filepath.Join(dir, filename[i+1:]))
+				} else {
+					filenames = append(filenames, filepath.Join(dir, filename))
+				}
+			}
+			models, err := LoadModels(filenames)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("LoadModels() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			var names []string
+			for _, name := range tt.want {
+				if _, ok := models[name]; ok {
+					names = append(names, name)
+				}
+			}
+			if len(models) != len(tt.want) || !reflect.DeepEqual(names, tt.want) {
+				t.Errorf("LoadModels() = %v, want models %v", models, tt.want)
+			}
+		})
+	}
+}
+
+func TestServerStream(t *testing.T) {
+	models := newTestModels(t)
+	server := httptest.NewServer(NewServer(models, ServerOptions{Timeout: time.Minute}))
+	defer server.Close()
+
+	resp, err := http.Post(server.URL+"/generate", "application/json", strings.NewReader(`{"model":"words","prompt":"this","max":20,"seed":5,"stream":true}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if got := resp.Header.Get("Content-Type"); got != "text/event-stream" {
+		t.Errorf("POST /generate Content-Type = %q, want text/event-stream", got)
+	}
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	events := strings.Split(strings.TrimSuffix(string(body), "\n\n"), "\n\n")
+	var text strings.Builder
+	for _, event := range events[:len(events)-1] {
+		var piece StreamEvent
+		if err := json.Unmarshal([]byte(strings.TrimPrefix(event, "data: ")), &piece); err != nil {
+			t.Fatalf("POST /generate event %q is not a StreamEvent: %v", event, err)
+		}
+		text.WriteString(piece.Text)
+	}
+	if want := chain.NewGenerator(models["words"], 5).Generate("this", 20); text.String() != want || len(events) < 3 {
+		t.Errorf("POST /generate streamed %q in %d events, want %q", text.String(), len(events)-1, want)
+	}
+	if done := events[len(events)-1]; done != `event: done`+"\n"+`data: {"model":"words","seed":5}` {
+		t.Errorf("POST /generate last event = %q, want done", done)
+	}
+}
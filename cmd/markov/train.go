@@ -0,0 +1,85 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/brannondorsey/markov/chain"
+	flag "github.com/spf13/pflag"
+)
+
+// runTrain implements the train command
+func runTrain(args []string) {
+	flags := flag.NewFlagSet("train", flag.ExitOnError)
+	train := addTrainFlags(flags)
+	output := flags.StringP("output", "o", "", "The model file to write.")
+	exportJSON := flags.String("export-json", "", "Also write the n-gram histogram to this file as JSON.")
+	mapped := flags.Bool("mapped", false, "Write the model in the memory-mapped layout, which loads instantly and is shared\nbetween the processes that load it.")
+	update := flags.String("update", "", "Add the transitions in the inputs to this model file instead of training a new one, and\nsave it back to the file unless --output is set. Options that aren't given default to\nthe ones the model was trained with, and those that are given must match them.")
+	help := flags.BoolP("help", "h", false, "Show this screen.")
+
+	flags.Usage = func() {
+		fmt.Printf("Usage: %s train [OPTIONS] --output <model> <input> [<input> ...]\n", os.Args[0])
+		fmt.Printf("       %s train [OPTIONS] --update <model> <input> [<input> ...]\n", os.Args[0])
+		fmt.Println("Trains a model on the inputs and saves it to a model file, which the generate, eval and")
+		fmt.Println("inspect commands read without needing the inputs. Inputs may be files, directories,")
+		fmt.Println("which are read recursively, glob patterns, or - to read standard input, and may be")
+		fmt.Println("compressed with gzip, bzip2 or zstd, or be tar archives.")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+	if flags.NArg() < 1 || (*output == "" && *update == "") || *help {
+		flags.Usage()
+		os.Exit(1)
+	}
+	if *update != "" {
+		header, err := ReadModelHeader(*update)
+		if err != nil {
+			fmt.Printf("[ERROR] Failed to read the model to update: %v.\n", err)
+			os.Exit(1)
+		}
+		train.inherit(flags, header.Options)
+		// Updated models keep their layout
+		*mapped = *mapped || header.Mapped
+		if *output == "" {
+			*output = *update
+		}
+	}
+	opts := train.options()
+	inputFilenames := train.inputs(flags.Args())
+
+	var model *chain.Model
+	var err error
+	if *update != "" {
+		model, err = UpdateModel(*update, inputFilenames, opts, train.training())
+		if err != nil {
+			fmt.Printf("[ERROR] Failed to update the model: %v.\n", err)
+			os.Exit(1)
+		}
+	} else {
+		model, err = TrainModel(inputFilenames, opts, train.training())
+		if err != nil {
+			fmt.Printf("[ERROR] Failed to train the model: %v.\n", err)
+			os.Exit(1)
+		}
+	}
+	if err := os.MkdirAll(filepath.Dir(*output), 0755); err != nil {
+		fmt.Printf("[ERROR] Failed to save the model: %v.\n", err)
+		os.Exit(1)
+	}
+	save := CacheModel
+	if *mapped {
+		save = SaveMappedModel
+	}
+	if err := save(model, *output); err != nil {
+		fmt.Printf("[ERROR] Failed to save the model: %v.\n", err)
+		os.Exit(1)
+	}
+	if *exportJSON != "" {
+		if err := ExportModelJSON(model, *exportJSON); err != nil {
+			fmt.Printf("[ERROR] Failed to export the histogram: %v.\n", err)
+			os.Exit(1)
+		}
+	}
+}
@@ -3,6 +3,8 @@ module github.com/brannondorsey/markov
 go 1.13
 
 require (
-	github.com/mroth/weightedrand v0.2.1
+	github.com/klauspost/compress v1.12.3
+	github.com/rivo/uniseg v0.2.0
 	github.com/spf13/pflag v1.0.5
+	golang.org/x/text v0.3.6
 )